@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Raw packet capture is only implemented on Linux
+type packetCapture struct{}
+
+func startCapture(store *signatureStore) (*packetCapture, error) {
+	return nil, errors.New("packet capture is not supported on this platform")
+}
+
+func (c *packetCapture) sendEcho(ip string) {}
+
+func (c *packetCapture) stop() {}
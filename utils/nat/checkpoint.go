@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Progress of a scan that can be written to disk and resumed
+type scanState struct {
+	mu         sync.Mutex
+	path       string
+	ports      []int
+	discovered bool
+	result     *ScanResult
+	done       map[string]map[int]bool // host IP -> ports already probed
+}
+
+// On-disk form of scanState
+type stateFile struct {
+	Ports      string            `json:"ports"`
+	Discovered bool              `json:"discovered"`
+	Result     *ScanResult       `json:"result"`
+	Done       map[string]string `json:"done"`
+}
+
+func newScanState(path string, result *ScanResult, ports []int) *scanState {
+	return &scanState{path: path, ports: ports, result: result, done: map[string]map[int]bool{}}
+}
+
+func loadScanState(path string) (*scanState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	ports, err := parsePortRanges(file.Ports)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	state := newScanState(path, file.Result, ports)
+	state.discovered = file.Discovered
+	for ip, spec := range file.Done {
+		done, err := parsePortRanges(spec)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		state.done[ip] = map[int]bool{}
+		for _, port := range done {
+			state.done[ip][port] = true
+		}
+	}
+	return state, nil
+}
+
+// Write the checkpoint atomically so an interrupted write never loses the previous one
+func (s *scanState) save() error {
+	s.mu.Lock()
+	file := stateFile{Ports: formatPortRanges(s.ports), Discovered: s.discovered, Result: s.result, Done: map[string]string{}}
+	for ip, ports := range s.done {
+		list := make([]int, 0, len(ports))
+		for port := range ports {
+			list = append(list, port)
+		}
+		file.Done[ip] = formatPortRanges(list)
+	}
+	data, err := json.Marshal(file)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Record that host discovery finished
+func (s *scanState) setDiscovered(hosts []*HostResult) {
+	s.mu.Lock()
+	s.discovered = true
+	s.result.Hosts = hosts
+	s.mu.Unlock()
+	s.save()
+}
+
+// Ports of a host that still need probing
+func (s *scanState) remaining(ip string) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var todo []int
+	for _, port := range s.ports {
+		if !s.done[ip][port] {
+			todo = append(todo, port)
+		}
+	}
+	return todo
+}
+
+// Record a probed port, adding it to the host when it is open
+func (s *scanState) markPort(host *HostResult, port int, open *PortResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done[host.IP] == nil {
+		s.done[host.IP] = map[int]bool{}
+	}
+	s.done[host.IP][port] = true
+	if open != nil {
+		host.Ports = append(host.Ports, open)
+	}
+}
+
+// Save every interval and on Ctrl-C until stop is called
+func (s *scanState) autosave(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	quit := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.save(); err != nil {
+					fmt.Fprintln(os.Stderr, "Error writing checkpoint:", err)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	removeCleanup := atInterrupt(func() {
+		if err := s.save(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing checkpoint:", err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\nInterrupted, progress saved to %s (continue with --resume)\n", s.path)
+	})
+
+	return func() {
+		removeCleanup()
+		ticker.Stop()
+		close(quit)
+	}
+}
+
+// The scan finished, so there is nothing left to resume
+func (s *scanState) remove() {
+	os.Remove(s.path)
+}
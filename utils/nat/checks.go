@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A custom check run against open ports. Add one by implementing this
+// interface in a new file of this package and calling registerCheck from init.
+type ScanCheck interface {
+	// Short unique name used with --checks and in reports
+	Name() string
+	// Ports and services (as named in the service database) the check applies to
+	Scope() CheckScope
+	// Run the check over a fresh connection to the port
+	Run(conn net.Conn, host *HostResult, port *PortResult) (*CheckResult, error)
+}
+
+// Which ports a check wants to see
+type CheckScope struct {
+	Ports    []int
+	Services []string
+}
+
+// Outcome of a check, attached to the port it ran on
+type CheckResult struct {
+	Check   string `json:"check"`
+	Finding bool   `json:"finding"`
+	Output  string `json:"output"`
+}
+
+const checkTimeout = 5 * time.Second
+
+var registeredChecks = map[string]ScanCheck{}
+
+func registerCheck(check ScanCheck) {
+	registeredChecks[check.Name()] = check
+}
+
+// Whether a port falls inside the scope of a check
+func (s CheckScope) matches(p *PortResult) bool {
+	for _, port := range s.Ports {
+		if port == p.Port {
+			return true
+		}
+	}
+	for _, service := range s.Services {
+		if strings.EqualFold(service, p.Service) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve the --checks value ("all" or a comma separated list of names)
+func selectChecks(spec string) ([]ScanCheck, error) {
+	names := checkNames()
+	if spec != "all" {
+		names = strings.Split(spec, ",")
+	}
+
+	var checks []ScanCheck
+	for _, name := range names {
+		check, ok := registeredChecks[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown check %q (available: %s)", name, strings.Join(checkNames(), ", "))
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func checkNames() []string {
+	var names []string
+	for name := range registeredChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run every selected check on the open ports it applies to. results
+// guards the ports while findings are added.
+func runChecks(hosts []*HostResult, checks []ScanCheck, results sync.Locker) {
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		for _, p := range h.Ports {
+			if p.State != "open" || p.Protocol != "tcp" {
+				continue
+			}
+			for _, check := range checks {
+				if !check.Scope().matches(p) {
+					continue
+				}
+				wg.Add(1)
+				go func(h *HostResult, p *PortResult, check ScanCheck) {
+					defer wg.Done()
+					result, err := runCheck(check, h, p)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Check %s on %s:%d failed: %v\n", check.Name(), h.IP, p.Port, err)
+						return
+					}
+					if result == nil {
+						return
+					}
+					result.Check = check.Name()
+					results.Lock()
+					p.Checks = append(p.Checks, result)
+					results.Unlock()
+				}(h, p, check)
+			}
+		}
+	}
+	wg.Wait()
+}
+
+func runCheck(check ScanCheck, h *HostResult, p *PortResult) (*CheckResult, error) {
+	conn, err := dialTCP(net.JoinHostPort(h.IP, strconv.Itoa(p.Port)), checkTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(checkTimeout))
+	return check.Run(conn, h, p)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+func init() {
+	registerCheck(redisNoAuth{})
+	registerCheck(ftpAnonymous{})
+	registerCheck(memcachedStats{})
+}
+
+// Redis that answers commands without AUTH
+type redisNoAuth struct{}
+
+func (redisNoAuth) Name() string { return "redis-noauth" }
+
+func (redisNoAuth) Scope() CheckScope {
+	return CheckScope{Ports: []int{6379}, Services: []string{"redis"}}
+}
+
+func (redisNoAuth) Run(conn net.Conn, host *HostResult, port *PortResult) (*CheckResult, error) {
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "PING\r\n")
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	reply = strings.TrimSpace(reply)
+	if !strings.HasPrefix(reply, "+PONG") {
+		return &CheckResult{Output: "authentication required (" + reply + ")"}, nil
+	}
+
+	output := "Redis accepts commands without authentication"
+	fmt.Fprint(conn, "INFO server\r\n")
+	if header, err := r.ReadString('\n'); err == nil && strings.HasPrefix(header, "$") {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || strings.TrimSpace(line) == "" {
+				break
+			}
+			if v, ok := strings.CutPrefix(strings.TrimSpace(line), "redis_version:"); ok {
+				output += " (version " + v + ")"
+				break
+			}
+		}
+	}
+	return &CheckResult{Finding: true, Output: output}, nil
+}
+
+// FTP servers that allow anonymous login
+type ftpAnonymous struct{}
+
+func (ftpAnonymous) Name() string { return "ftp-anon" }
+
+func (ftpAnonymous) Scope() CheckScope {
+	return CheckScope{Ports: []int{21}, Services: []string{"ftp"}}
+}
+
+// Read a possibly multi-line FTP reply ("220-..." continued until "220 ...")
+func readFTPReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) >= 4 && line[3] == '-' {
+		code := line[:3]
+		for {
+			next, err := r.ReadString('\n')
+			if err != nil {
+				return "", err
+			}
+			if strings.HasPrefix(next, code+" ") {
+				break
+			}
+		}
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func (ftpAnonymous) Run(conn net.Conn, host *HostResult, port *PortResult) (*CheckResult, error) {
+	r := bufio.NewReader(conn)
+	if _, err := readFTPReply(r); err != nil {
+		return nil, err
+	}
+	fmt.Fprint(conn, "USER anonymous\r\n")
+	reply, err := readFTPReply(r)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(reply, "331") {
+		fmt.Fprint(conn, "PASS anonymous@example.com\r\n")
+		if reply, err = readFTPReply(r); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprint(conn, "QUIT\r\n")
+	if strings.HasPrefix(reply, "230") {
+		return &CheckResult{Finding: true, Output: "anonymous login allowed"}, nil
+	}
+	return &CheckResult{Output: "anonymous login refused (" + reply + ")"}, nil
+}
+
+// Memcached that hands out its stats to anyone
+type memcachedStats struct{}
+
+func (memcachedStats) Name() string { return "memcached-stats" }
+
+func (memcachedStats) Scope() CheckScope {
+	return CheckScope{Ports: []int{11211}, Services: []string{"memcache"}}
+}
+
+func (memcachedStats) Run(conn net.Conn, host *HostResult, port *PortResult) (*CheckResult, error) {
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "stats\r\n")
+	stats := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "END" {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			return &CheckResult{Output: "stats refused (" + line + ")"}, nil
+		}
+		stats[fields[1]] = fields[2]
+	}
+	fmt.Fprint(conn, "quit\r\n")
+	return &CheckResult{
+		Finding: true,
+		Output: fmt.Sprintf("stats open to anyone: version %s, %s items, %s connections",
+			stats["version"], stats["curr_items"], stats["curr_connections"]),
+	}, nil
+}
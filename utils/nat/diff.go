@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Changes between two scans of the same target
+type ScanDiff struct {
+	Target    string       `json:"target"`
+	Previous  time.Time    `json:"previous"`
+	Current   time.Time    `json:"current"`
+	NewHosts  []string     `json:"new_hosts,omitempty"`
+	GoneHosts []string     `json:"gone_hosts,omitempty"`
+	Opened    []PortChange `json:"opened,omitempty"`
+	Closed    []PortChange `json:"closed,omitempty"`
+}
+
+// A port that changed state between two scans
+type PortChange struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Service  string `json:"service,omitempty"`
+}
+
+// Load a report previously written with --format json
+func loadReport(path string) (*ScanResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := &ScanResult{}
+	if err := json.NewDecoder(file).Decode(result); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return result, nil
+}
+
+// Compare two scans. Hosts present in both are checked for opened and closed ports.
+func diffResults(old, cur *ScanResult) *ScanDiff {
+	old.sort()
+	cur.sort()
+	diff := &ScanDiff{Target: cur.Target, Previous: old.Finished, Current: cur.Finished}
+
+	for _, h := range cur.Hosts {
+		prev := old.host(h.IP)
+		if prev == nil {
+			diff.NewHosts = append(diff.NewHosts, h.IP)
+			diff.Opened = append(diff.Opened, openPortChanges(h, nil)...)
+			continue
+		}
+		diff.Opened = append(diff.Opened, openPortChanges(h, prev)...)
+		diff.Closed = append(diff.Closed, openPortChanges(prev, h)...)
+	}
+	for _, h := range old.Hosts {
+		if cur.host(h.IP) == nil {
+			diff.GoneHosts = append(diff.GoneHosts, h.IP)
+		}
+	}
+	return diff
+}
+
+// Open ports of host that are not open on other (other may be nil)
+func openPortChanges(host, other *HostResult) []PortChange {
+	var changes []PortChange
+	for _, p := range host.Ports {
+		if p.State != "open" {
+			continue
+		}
+		if other != nil {
+			if q := other.port(p.Port, p.Protocol); q != nil && q.State == "open" {
+				continue
+			}
+		}
+		changes = append(changes, PortChange{IP: host.IP, Port: p.Port, Protocol: p.Protocol, Service: p.Service})
+	}
+	return changes
+}
+
+// Find a port result by number and protocol
+func (h *HostResult) port(port int, protocol string) *PortResult {
+	for _, p := range h.Ports {
+		if p.Port == port && p.Protocol == protocol {
+			return p
+		}
+	}
+	return nil
+}
+
+// Whether the two scans were identical
+func (d *ScanDiff) empty() bool {
+	return len(d.NewHosts) == 0 && len(d.GoneHosts) == 0 && len(d.Opened) == 0 && len(d.Closed) == 0
+}
+
+// Human-readable change list
+func writeDiff(w io.Writer, d *ScanDiff) error {
+	if d.empty() {
+		_, err := fmt.Fprintf(w, "No changes on %s since %s\n", d.Target, d.Previous.Format(time.RFC1123))
+		return err
+	}
+	fmt.Fprintf(w, "Changes on %s since %s:\n", d.Target, d.Previous.Format(time.RFC1123))
+	for _, ip := range d.NewHosts {
+		fmt.Fprintf(w, "+ host %s is up\n", ip)
+	}
+	for _, ip := range d.GoneHosts {
+		fmt.Fprintf(w, "- host %s disappeared\n", ip)
+	}
+	for _, p := range d.Opened {
+		fmt.Fprintf(w, "+ %s %d/%s opened (%s)\n", p.IP, p.Port, p.Protocol, p.Service)
+	}
+	for _, p := range d.Closed {
+		fmt.Fprintf(w, "- %s %d/%s closed (%s)\n", p.IP, p.Port, p.Protocol, p.Service)
+	}
+	return nil
+}
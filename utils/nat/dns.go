@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// Minimal DNS wire format support for mDNS, LLMNR and NetBIOS queries
+
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsTypeNB   = 33 // NetBIOS node status shares the SRV type code
+)
+
+var errShortDNS = errors.New("short DNS message")
+
+// A resource record from a response
+type dnsRecord struct {
+	Name   string
+	Type   uint16
+	Data   []byte // raw RDATA
+	IP     net.IP // A and AAAA
+	Target string // PTR and SRV
+	Port   uint16 // SRV
+}
+
+// Encode a dotted name as DNS labels
+func encodeDNSName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// Build a query with a single question; encodedName is already in label form
+func buildDNSQuery(id uint16, encodedName []byte, qtype uint16) []byte {
+	msg := make([]byte, 12, 12+len(encodedName)+4)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+	msg = append(msg, encodedName...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, 1) // class IN
+}
+
+// Read a possibly compressed name starting at off; returns the name and the offset after it
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; jumps < 32; {
+		if off >= len(msg) {
+			return "", 0, errShortDNS
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errShortDNS
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errShortDNS
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+	return "", 0, errors.New("DNS name compression loop")
+}
+
+// Parse the answer, authority and additional sections of a message
+func parseDNSMessage(msg []byte) ([]dnsRecord, error) {
+	if len(msg) < 12 {
+		return nil, errShortDNS
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rr := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qd; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+
+	var records []dnsRecord
+	for i := 0; i < rr; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil || next+10 > len(msg) {
+			return records, errShortDNS
+		}
+		rec := dnsRecord{Name: name, Type: binary.BigEndian.Uint16(msg[next:])}
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+rdlen > len(msg) {
+			return records, errShortDNS
+		}
+		rec.Data = msg[start : start+rdlen]
+
+		switch rec.Type {
+		case dnsTypeA, dnsTypeAAAA:
+			if rdlen == 4 || rdlen == 16 {
+				rec.IP = net.IP(rec.Data)
+			}
+		case dnsTypePTR:
+			rec.Target, _, _ = readDNSName(msg, start)
+		case dnsTypeSRV:
+			if rdlen > 6 {
+				rec.Port = binary.BigEndian.Uint16(rec.Data[4:])
+				rec.Target, _, _ = readDNSName(msg, start+6)
+			}
+		}
+		records = append(records, rec)
+		off = start + rdlen
+	}
+	return records, nil
+}
+
+// Reverse lookup name of an IP, e.g. 4.3.2.1.in-addr.arpa
+func reverseDNSName(ip string) string {
+	addr := hostIP(ip)
+	if addr == nil {
+		return ""
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return net.IPv4(v4[3], v4[2], v4[1], v4[0]).String() + ".in-addr.arpa"
+	}
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(addr) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[addr[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[addr[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+// Send one UDP query and wait for the first reply
+func udpQuery(addr string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 9000)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
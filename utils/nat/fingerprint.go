@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What a host revealed about its TCP/IP stack
+type stackSignature struct {
+	TTL     int    // TTL as received
+	Window  int    // TCP window of a SYN/ACK, 0 if none was seen
+	Options string // TCP option layout of a SYN/ACK, e.g. "M,S,T,N,W"
+}
+
+// Best-guess operating system of a host
+type OSGuess struct {
+	Family     string `json:"family"`
+	Confidence int    `json:"confidence"`
+	InitialTTL int    `json:"initial_ttl,omitempty"`
+	Window     int    `json:"window,omitempty"`
+	Options    string `json:"tcp_options,omitempty"`
+}
+
+// Known stack behaviour per OS family
+type osFingerprint struct {
+	family  string
+	ttl     int
+	windows []int
+	options []string
+}
+
+var osFingerprints = []osFingerprint{
+	{family: "Linux", ttl: 64, windows: []int{5792, 14480, 28960, 43440, 64240, 65160}, options: []string{"M,S,T,N,W", "M,N,N,S,N,W"}},
+	{family: "Windows", ttl: 128, windows: []int{8192, 64240, 65535}, options: []string{"M,N,W,N,N,S", "M,N,W,S,T", "M,N,W,N,N,T,S"}},
+	{family: "macOS/iOS", ttl: 64, windows: []int{65535}, options: []string{"M,N,W,N,N,T,S,E", "M,N,W,S,T"}},
+	{family: "FreeBSD/OpenBSD", ttl: 64, windows: []int{65535, 16384}, options: []string{"M,N,W,S,T", "M,N,N,S,N,W,N,N,T"}},
+	{family: "Network device (Cisco IOS, embedded)", ttl: 255, windows: []int{4128, 8192}, options: []string{"M"}},
+	{family: "Solaris", ttl: 255, windows: []int{49232, 64240}, options: []string{"N,N,T,M,N,W,N,N,S", "M,N,W,N,N,S"}},
+}
+
+// Round a received TTL up to the initial TTL the sender most likely used
+func initialTTL(ttl int) int {
+	for _, initial := range []int{32, 64, 128, 255} {
+		if ttl <= initial {
+			return initial
+		}
+	}
+	return 255
+}
+
+// Compare the option kinds ignoring their order
+func sameOptionSet(a, b string) bool {
+	x, y := strings.Split(a, ","), strings.Split(b, ",")
+	sort.Strings(x)
+	sort.Strings(y)
+	return strings.Join(x, ",") == strings.Join(y, ",")
+}
+
+// Score every fingerprint and return the best match. The score is the share of
+// evidence that matched: TTL 40, window 20 and option layout 40 points.
+func guessOS(sig stackSignature) *OSGuess {
+	if sig.TTL == 0 {
+		return nil
+	}
+	guess := &OSGuess{InitialTTL: initialTTL(sig.TTL), Window: sig.Window, Options: sig.Options}
+
+	best, bestScore, possible := "", -1, 40
+	if sig.Window > 0 {
+		possible += 20
+	}
+	if sig.Options != "" {
+		possible += 40
+	}
+	for _, fp := range osFingerprints {
+		score := 0
+		if fp.ttl == guess.InitialTTL {
+			score += 40
+		}
+		for _, w := range fp.windows {
+			if sig.Window > 0 && w == sig.Window {
+				score += 20
+				break
+			}
+		}
+		optionScore := 0
+		for _, o := range fp.options {
+			if sig.Options == "" {
+				break
+			}
+			if o == sig.Options {
+				optionScore = 40
+				break
+			}
+			if sameOptionSet(o, sig.Options) {
+				optionScore = 20
+			}
+		}
+		score += optionScore
+		if score > bestScore {
+			best, bestScore = fp.family, score
+		}
+	}
+	if bestScore <= 0 {
+		guess.Family = "Unknown"
+		return guess
+	}
+	guess.Family = best
+	guess.Confidence = bestScore * 100 / possible
+	// A TTL alone cannot tell Linux from macOS or BSD
+	if sig.Window == 0 && sig.Options == "" && guess.Confidence > 50 {
+		guess.Confidence = 50
+	}
+	return guess
+}
+
+// Collected signatures per host IP
+type signatureStore struct {
+	mu   sync.Mutex
+	sigs map[string]*stackSignature
+}
+
+func newSignatureStore() *signatureStore {
+	return &signatureStore{sigs: make(map[string]*stackSignature)}
+}
+
+// Record a TTL seen from ip; SYN/ACK details win over ICMP-only data
+func (s *signatureStore) add(ip string, sig stackSignature) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.sigs[ip]
+	if !ok || (prev.Options == "" && sig.Options != "") {
+		s.sigs[ip] = &sig
+	}
+}
+
+func (s *signatureStore) get(ip string) (stackSignature, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sig, ok := s.sigs[ip]
+	if !ok {
+		return stackSignature{}, false
+	}
+	return *sig, true
+}
+
+var pingTTL = regexp.MustCompile(`(?i)ttl[=:]\s*(\d+)`)
+
+// Fallback without raw sockets: read the reply TTL from the system ping command
+func pingForTTL(ip string) int {
+	countFlag := "-c"
+	if runtime.GOOS == "windows" {
+		countFlag = "-n"
+	}
+	out, err := exec.Command("ping", countFlag, "1", ip).Output()
+	if err != nil {
+		return 0
+	}
+	if m := pingTTL.FindSubmatch(out); m != nil {
+		ttl, _ := strconv.Atoi(string(m[1]))
+		return ttl
+	}
+	return 0
+}
+
+// Guess the OS of every host. run performs the scanning that makes hosts
+// answer (port scan); the capture only listens to the replies.
+func fingerprintHosts(hosts []*HostResult, results sync.Locker, run func()) {
+	store := newSignatureStore()
+	capture, err := startCapture(store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "OS detection without packet capture (%v), using ping TTL only\n", err)
+	}
+
+	run()
+
+	if capture != nil {
+		for _, h := range hosts {
+			capture.sendEcho(h.IP)
+		}
+		time.Sleep(500 * time.Millisecond)
+		capture.stop()
+	}
+
+	for _, h := range hosts {
+		sig, ok := store.get(h.IP)
+		if !ok {
+			if ttl := pingForTTL(h.IP); ttl > 0 {
+				sig = stackSignature{TTL: ttl}
+			}
+		}
+		guess := guessOS(sig)
+		results.Lock()
+		h.OS = guess
+		results.Unlock()
+	}
+}
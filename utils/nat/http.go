@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths that often reveal forgotten admin panels or leaked files
+var wellKnownPaths = []string{
+	"/robots.txt",
+	"/.git/HEAD",
+	"/.svn/entries",
+	"/.env",
+	"/server-status",
+	"/server-info",
+	"/admin/",
+	"/phpmyadmin/",
+	"/wp-login.php",
+	"/actuator/health",
+}
+
+// What a web server on a port answered
+type HTTPInfo struct {
+	URL       string       `json:"url"`
+	Status    int          `json:"status"`
+	Server    string       `json:"server,omitempty"`
+	Title     string       `json:"title,omitempty"`
+	Redirects []string     `json:"redirects,omitempty"`
+	Paths     []PathStatus `json:"paths,omitempty"`
+}
+
+// Status code of a well-known path
+type PathStatus struct {
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+const httpTimeout = 5 * time.Second
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Probe every open port that may speak HTTP and attach what was found.
+// results guards the ports while they are written.
+func enumerateWebPorts(hosts []*HostResult, results sync.Locker) {
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		for _, p := range h.Ports {
+			if p.State != "open" || p.Protocol != "tcp" || !mayBeHTTP(p) {
+				continue
+			}
+			wg.Add(1)
+			go func(ip string, p *PortResult) {
+				defer wg.Done()
+				info := enumerateHTTP(ip, p.Port)
+				results.Lock()
+				p.HTTP = info
+				results.Unlock()
+			}(h.IP, p)
+		}
+	}
+	wg.Wait()
+}
+
+// Services that greet first (SSH, FTP, SMTP...) are not web servers
+func mayBeHTTP(p *PortResult) bool {
+	return p.Banner == "" || strings.HasPrefix(p.Banner, "HTTP/")
+}
+
+// Base URL of a port, preferring HTTPS when the port completes a TLS handshake
+func webBaseURL(ip string, port int) string {
+	// Zones must be escaped inside URLs: [fe80::1%25eth0]
+	host := net.JoinHostPort(strings.Replace(ip, "%", "%25", 1), strconv.Itoa(port))
+	conn, err := dialTCP(net.JoinHostPort(ip, strconv.Itoa(port)), 2*time.Second)
+	if err != nil {
+		return "http://" + host
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if tls.Client(conn, &tls.Config{InsecureSkipVerify: true}).Handshake() == nil {
+		return "https://" + host
+	}
+	return "http://" + host
+}
+
+func newHTTPClient(follow bool, redirects *[]string) *http.Client {
+	return &http.Client{
+		Timeout: httpTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialTCP(addr, httpTimeout)
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !follow {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= 5 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			*redirects = append(*redirects, req.URL.String())
+			// Only the scanned service is probed; a redirect elsewhere is
+			// recorded but not followed
+			if !sameHostPort(req.URL, via[0].URL) {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// Whether two URLs point at the same host and port
+func sameHostPort(a, b *url.URL) bool {
+	return strings.EqualFold(a.Hostname(), b.Hostname()) && urlPort(a) == urlPort(b)
+}
+
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// Fetch / (following redirects) and the well-known paths (not following them)
+func enumerateHTTP(ip string, port int) *HTTPInfo {
+	base := webBaseURL(ip, port)
+	info := &HTTPInfo{URL: base + "/"}
+
+	resp, err := newHTTPClient(true, &info.Redirects).Get(info.URL)
+	if err != nil {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	info.Status = resp.StatusCode
+	info.Server = resp.Header.Get("Server")
+	if m := titlePattern.FindSubmatch(body); m != nil {
+		info.Title = strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	}
+
+	client := newHTTPClient(false, nil)
+	for _, path := range wellKnownPaths {
+		resp, err := client.Get(base + path)
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		info.Paths = append(info.Paths, PathStatus{Path: path, Status: resp.StatusCode})
+	}
+	return info
+}
+
+// One-line summary used by the text and XML reports
+func (h *HTTPInfo) summary() string {
+	s := fmt.Sprintf("%s %d", h.URL, h.Status)
+	if h.Server != "" {
+		s += " " + h.Server
+	}
+	if h.Title != "" {
+		s += fmt.Sprintf(" %q", h.Title)
+	}
+	return s
+}
+
+// Paths that exist or are access controlled, i.e. not 404
+func (h *HTTPInfo) interestingPaths() []PathStatus {
+	var paths []PathStatus
+	for _, p := range h.Paths {
+		if p.Status != http.StatusNotFound {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Work to do before exiting on Ctrl-C, such as restoring the terminal or
+// saving a checkpoint. One handler runs all of it so none is skipped.
+var (
+	interruptMu       sync.Mutex
+	interruptCleanups = map[int]func(){}
+	nextCleanupID     int
+	interruptSignals  chan os.Signal
+)
+
+// Run cleanup if the program is interrupted before remove is called.
+// Cleanups run newest first, then the program exits with status 130.
+func atInterrupt(cleanup func()) (remove func()) {
+	interruptMu.Lock()
+	defer interruptMu.Unlock()
+	id := nextCleanupID
+	nextCleanupID++
+	interruptCleanups[id] = cleanup
+	if interruptSignals == nil {
+		interruptSignals = make(chan os.Signal, 1)
+		signal.Notify(interruptSignals, os.Interrupt)
+		go handleInterrupt(interruptSignals)
+	}
+
+	return func() {
+		interruptMu.Lock()
+		defer interruptMu.Unlock()
+		delete(interruptCleanups, id)
+		// With nothing left to clean up Ctrl-C behaves as usual again
+		if len(interruptCleanups) == 0 && interruptSignals != nil {
+			signal.Stop(interruptSignals)
+			close(interruptSignals)
+			interruptSignals = nil
+		}
+	}
+}
+
+func handleInterrupt(signals chan os.Signal) {
+	if _, ok := <-signals; !ok {
+		return
+	}
+	interruptMu.Lock()
+	for id := nextCleanupID - 1; id >= 0; id-- {
+		if cleanup, ok := interruptCleanups[id]; ok {
+			cleanup()
+		}
+	}
+	os.Exit(130)
+}
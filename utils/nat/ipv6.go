@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Strip an IPv6 zone ("fe80::1%eth0") before parsing
+func hostIP(s string) net.IP {
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+// Whether a prefix is small enough to try every address in it
+func canBruteForce(ipnet *net.IPNet, maxPrefix int) bool {
+	if ipnet.IP.To4() != nil {
+		return true
+	}
+	ones, _ := ipnet.Mask.Size()
+	return ones >= maxPrefix
+}
+
+// Find IPv6 hosts inside ipnet without enumerating it: multicast ping to
+// ff02::1, neighbor solicitations for likely addresses and the neighbor cache
+func discoverIPv6(ipnet *net.IPNet) []string {
+	found := map[string]bool{}
+	add := func(addr string) {
+		if ip := hostIP(addr); ip != nil && ip.To4() == nil && ipnet.Contains(ip) {
+			found[addr] = true
+		}
+	}
+
+	ifaces := multicastInterfaces()
+	linkLocal := map[string]bool{}
+	for _, addr := range pingAllNodes(ifaces) {
+		linkLocal[addr] = true
+		add(addr)
+	}
+
+	// Hosts answer the all-nodes ping from their link-local address. Hosts using
+	// EUI-64 or stable interface IDs use the same ID in every prefix, so try
+	// the target prefix with those IDs and with the EUI-64 of known MACs.
+	var candidates []net.IP
+	for addr := range linkLocal {
+		candidates = append(candidates, withInterfaceID(ipnet, hostIP(addr)))
+	}
+	for _, mac := range readNeighbors() {
+		if hw, err := net.ParseMAC(mac); err == nil && len(hw) == 6 {
+			candidates = append(candidates, withInterfaceID(ipnet, eui64(hw)))
+		}
+	}
+	// Statically numbered servers and routers are usually at the low end
+	for i := 1; i <= 16; i++ {
+		candidates = append(candidates, withInterfaceID(ipnet, net.IP{15: byte(i)}))
+	}
+
+	for _, ip := range candidates {
+		if ip == nil || !ipnet.Contains(ip) {
+			continue
+		}
+		for _, iface := range zonesFor(ip, ifaces) {
+			// Any datagram makes the kernel send a neighbor solicitation first
+			if conn, err := net.Dial("udp6", net.JoinHostPort(zoned(ip.String(), iface), "9")); err == nil {
+				conn.Write([]byte{0})
+				conn.Close()
+			}
+		}
+	}
+	time.Sleep(time.Second)
+
+	for addr := range readNeighbors() {
+		add(addr)
+	}
+
+	addrs := make([]string, 0, len(found))
+	for addr := range found {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// Put the last 64 bits of id into the prefix of ipnet (only for /64 and shorter prefixes)
+func withInterfaceID(ipnet *net.IPNet, id net.IP) net.IP {
+	ones, bits := ipnet.Mask.Size()
+	if id == nil || bits != 128 || ones > 64 {
+		return nil
+	}
+	ip := make(net.IP, 16)
+	copy(ip, ipnet.IP.To16())
+	copy(ip[8:], id.To16()[8:])
+	return ip
+}
+
+// Modified EUI-64 interface ID of a MAC address
+func eui64(hw net.HardwareAddr) net.IP {
+	ip := make(net.IP, 16)
+	ip[8] = hw[0] ^ 0x02
+	ip[9], ip[10] = hw[1], hw[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = hw[3], hw[4], hw[5]
+	return ip
+}
+
+// Link-local addresses need a zone; other addresses are routed normally
+func zonesFor(ip net.IP, ifaces []net.Interface) []string {
+	if !ip.IsLinkLocalUnicast() {
+		return []string{""}
+	}
+	zones := make([]string, 0, len(ifaces))
+	for _, iface := range ifaces {
+		zones = append(zones, iface.Name)
+	}
+	return zones
+}
+
+func zoned(ip, zone string) string {
+	if zone == "" {
+		return ip
+	}
+	return ip + "%" + zone
+}
+
+// Interfaces that are up, not loopback and can send IPv6 multicast
+func multicastInterfaces() []net.Interface {
+	var result []net.Interface
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.To4() == nil {
+				result = append(result, iface)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Send an ICMPv6 echo request to ff02::1 on every interface and collect who answers
+func pingAllNodes(ifaces []net.Interface) []string {
+	conn, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return pingAllNodesCommand(ifaces)
+	}
+	defer conn.Close()
+
+	echo := []byte{128, 0, 0, 0, 0x4e, 0x53, 0, 1} // checksum is filled in by the kernel
+	for _, iface := range ifaces {
+		conn.WriteTo(echo, &net.IPAddr{IP: net.ParseIP("ff02::1"), Zone: iface.Name})
+	}
+
+	var addrs []string
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n < 8 || buf[0] != 129 {
+			continue
+		}
+		if a, ok := from.(*net.IPAddr); ok {
+			addrs = append(addrs, zoned(a.IP.String(), a.Zone))
+		}
+	}
+	return addrs
+}
+
+var pingFrom = regexp.MustCompile(`(?i)from ([0-9a-f:]+(?:%[\w.-]+)?)`)
+
+// Unprivileged fallback using the system ping command
+func pingAllNodesCommand(ifaces []net.Interface) []string {
+	var addrs []string
+	for _, iface := range ifaces {
+		var cmd *exec.Cmd
+		switch runtime.GOOS {
+		case "linux":
+			cmd = exec.Command("ping", "-6", "-c", "2", "-w", "2", "ff02::1%"+iface.Name)
+		case "windows":
+			// Windows does not answer or ping multicast; rely on the neighbor cache
+			continue
+		default:
+			cmd = exec.Command("ping6", "-c", "2", "ff02::1%"+iface.Name)
+		}
+		out, _ := cmd.Output()
+		for _, m := range pingFrom.FindAllStringSubmatch(string(out), -1) {
+			addr := strings.TrimSuffix(m[1], ":")
+			if !strings.Contains(addr, "%") && hostIP(addr).IsLinkLocalUnicast() {
+				addr = zoned(addr, iface.Name)
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+var neigh6Line = regexp.MustCompile(`(?i)^\s*([0-9a-f:]*:[0-9a-f:]*(?:%[\w.-]+)?)\s.*?([0-9a-f]{1,2}(?:[:-][0-9a-f]{1,2}){5})`)
+
+// Read the IPv6 neighbor cache: ip -6 neigh (Linux), ndp -an (macOS/BSD), netsh (Windows)
+func readNeighbors6() map[string]string {
+	neighbors := map[string]string{}
+	var out []byte
+	var err error
+	switch runtime.GOOS {
+	case "linux":
+		out, err = exec.Command("ip", "-6", "neigh", "show").Output()
+	case "windows":
+		out, err = exec.Command("netsh", "interface", "ipv6", "show", "neighbors").Output()
+	default:
+		out, err = exec.Command("ndp", "-an").Output()
+	}
+	if err != nil {
+		return neighbors
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.Contains(line, "FAILED") || strings.Contains(line, "INCOMPLETE") || strings.Contains(line, "Unreachable") {
+			continue
+		}
+		m := neigh6Line.FindStringSubmatch(line)
+		if m == nil || hostIP(m[1]) == nil {
+			continue
+		}
+		hw, err := net.ParseMAC(normalizeMAC(m[2]))
+		if err != nil || isZero(hw) {
+			continue
+		}
+		addr := m[1]
+		// ip -6 neigh prints the interface separately: "fe80::1 dev eth0 lladdr ..."
+		if fields := strings.Fields(line); hostIP(addr).IsLinkLocalUnicast() && !strings.Contains(addr, "%") && len(fields) > 2 && fields[1] == "dev" {
+			addr = zoned(addr, fields[2])
+		}
+		neighbors[addr] = hw.String()
+	}
+	return neighbors
+}
+
+// Tell the user why an IPv6 prefix is not enumerated
+func explainIPv6Discovery(ipnet *net.IPNet, maxPrefix int) {
+	fmt.Fprintf(os.Stderr, "Not enumerating %s (larger than /%d), using multicast ping and neighbor discovery\n", ipnet, maxPrefix)
+}
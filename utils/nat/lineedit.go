@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const maxHistory = 1000
+
+// Line editor for the interactive shell: cursor movement, history and tab
+// completion when stdin is a terminal, plain line reading otherwise
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal bool
+	history  []string
+	histPath string
+	// Candidates for the last word of line, which is the text before the cursor
+	complete func(line string) []string
+
+	// Line being edited
+	prompt    string
+	buf       []rune
+	pos       int
+	histIndex int
+	saved     string // the new line while browsing history
+}
+
+func newLineEditor(histPath string, complete func(line string) []string) *lineEditor {
+	e := &lineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		terminal: isTerminal(os.Stdin),
+		histPath: histPath,
+		complete: complete,
+	}
+	e.loadHistory()
+	return e
+}
+
+func (e *lineEditor) loadHistory() {
+	data, err := os.ReadFile(e.histPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// Remember a line in memory and in the history file
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.histPath == "" {
+		return
+	}
+	file, err := os.OpenFile(e.histPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(file, line)
+	file.Close()
+}
+
+// Read one line, returning io.EOF on Ctrl-D or end of input
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.terminal {
+		if restore, err := makeRaw(os.Stdin); err == nil {
+			defer restore()
+			line, err := e.edit(prompt)
+			if err == nil {
+				e.addHistory(strings.TrimSpace(line))
+			}
+			return line, err
+		}
+	}
+
+	fmt.Fprint(e.out, prompt)
+	line, err := e.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	e.addHistory(strings.TrimSpace(line))
+	return line, nil
+}
+
+func (e *lineEditor) redraw() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.buf))
+	if back := len(e.buf) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *lineEditor) setLine(s string) {
+	e.buf = []rune(s)
+	e.pos = len(e.buf)
+	e.redraw()
+}
+
+func (e *lineEditor) insert(s string) {
+	r := []rune(s)
+	e.buf = append(e.buf[:e.pos], append(r, e.buf[e.pos:]...)...)
+	e.pos += len(r)
+	e.redraw()
+}
+
+// Remove the runes between from and to and put the cursor there
+func (e *lineEditor) cut(from, to int) {
+	e.buf = append(e.buf[:from], e.buf[to:]...)
+	e.pos = from
+	e.redraw()
+}
+
+// Editing loop in raw mode
+func (e *lineEditor) edit(prompt string) (string, error) {
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	e.histIndex, e.saved = len(e.history), ""
+	lastTab := false
+
+	e.redraw()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case r == '\r' || r == '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.buf), nil
+		case r == 3: // Ctrl-C abandons the line
+			fmt.Fprint(e.out, "^C\r\n")
+			e.buf, e.pos = nil, 0
+			e.histIndex = len(e.history)
+			e.redraw()
+		case r == 4: // Ctrl-D
+			if len(e.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if e.pos < len(e.buf) {
+				e.cut(e.pos, e.pos+1)
+			}
+		case r == 127 || r == 8:
+			if e.pos > 0 {
+				e.cut(e.pos-1, e.pos)
+			}
+		case r == 1: // Ctrl-A
+			e.pos = 0
+			e.redraw()
+		case r == 5: // Ctrl-E
+			e.pos = len(e.buf)
+			e.redraw()
+		case r == 11: // Ctrl-K
+			e.buf = e.buf[:e.pos]
+			e.redraw()
+		case r == 21: // Ctrl-U
+			e.cut(0, e.pos)
+		case r == 23: // Ctrl-W deletes the word before the cursor
+			start := e.pos
+			for start > 0 && e.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.buf[start-1] != ' ' {
+				start--
+			}
+			e.cut(start, e.pos)
+		case r == 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+			e.redraw()
+		case r == '\t':
+			e.completeWord(lastTab)
+		case r == 27:
+			e.escape()
+		case unicode.IsPrint(r):
+			e.insert(string(r))
+		}
+		lastTab = r == '\t'
+	}
+}
+
+// Complete the word before the cursor; a second Tab lists the candidates
+func (e *lineEditor) completeWord(again bool) {
+	if e.complete == nil {
+		return
+	}
+	before := string(e.buf[:e.pos])
+	word := before[strings.LastIndex(before, " ")+1:]
+	candidates := e.complete(before)
+	switch {
+	case len(candidates) == 1:
+		e.insert(strings.TrimPrefix(candidates[0], word))
+		if !strings.HasSuffix(candidates[0], string(filepath.Separator)) {
+			e.insert(" ")
+		}
+	case len(candidates) > 1:
+		if common := commonPrefix(candidates); len(common) > len(word) {
+			e.insert(strings.TrimPrefix(common, word))
+		} else if again {
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+			e.redraw()
+		}
+	}
+}
+
+// Handle an escape sequence: arrow keys, Home, End and Delete
+func (e *lineEditor) escape() {
+	next, _, err := e.in.ReadRune()
+	if err != nil || (next != '[' && next != 'O') {
+		return
+	}
+	seq := ""
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return
+		}
+		seq += string(r)
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+	}
+
+	switch seq {
+	case "A": // up
+		if e.histIndex > 0 {
+			if e.histIndex == len(e.history) {
+				e.saved = string(e.buf)
+			}
+			e.histIndex--
+			e.setLine(e.history[e.histIndex])
+		}
+	case "B": // down
+		if e.histIndex < len(e.history) {
+			e.histIndex++
+			if e.histIndex == len(e.history) {
+				e.setLine(e.saved)
+			} else {
+				e.setLine(e.history[e.histIndex])
+			}
+		}
+	case "C":
+		if e.pos < len(e.buf) {
+			e.pos++
+			e.redraw()
+		}
+	case "D":
+		if e.pos > 0 {
+			e.pos--
+			e.redraw()
+		}
+	case "H", "1~", "7~":
+		e.pos = 0
+		e.redraw()
+	case "F", "4~", "8~":
+		e.pos = len(e.buf)
+		e.redraw()
+	case "3~":
+		if e.pos < len(e.buf) {
+			e.cut(e.pos, e.pos+1)
+		}
+	}
+}
+
+func commonPrefix(list []string) string {
+	prefix := list[0]
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// Candidates starting with word
+func completeFrom(word string, options []string) []string {
+	var matches []string
+	for _, o := range options {
+		if strings.HasPrefix(o, word) && !contains(matches, o) {
+			matches = append(matches, o)
+		}
+	}
+	return matches
+}
+
+// File and directory names starting with word; directories end in a separator
+func completeFiles(word string) []string {
+	dir, base := filepath.Split(word)
+	path := dir
+	if path == "" {
+		path = "."
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	var matches []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), base) {
+			continue
+		}
+		name := dir + entry.Name()
+		if entry.IsDir() {
+			name += string(filepath.Separator)
+		}
+		matches = append(matches, name)
+	}
+	return matches
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//go:embed oui.txt
+var bundledOUI string
+
+// MAC prefix -> vendor, keyed by upper-case hex digits of the prefix
+// (6 digits for normal OUIs, 7 or 9 for the /28 and /36 blocks)
+var ouiVendors = map[string]string{}
+
+// Load the bundled OUI database, or the file given with --oui
+func loadOUI(path string) error {
+	if path == "" {
+		return parseOUI(strings.NewReader(bundledOUI))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return parseOUI(file)
+}
+
+// Parse IEEE oui.txt, Wireshark manuf or the bundled "prefix vendor" format
+func parseOUI(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	ieee := false // Seen an oui.txt record, so only record lines count
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// oui.txt address lines can look like prefixes too ("D-80333"),
+		// so there only "(hex)" and "(base 16)" lines are records
+		record := fields[1] == "(hex)" || fields[1] == "(base" && len(fields) > 2 && fields[2] == "16)"
+		ieee = ieee || record
+		if !record && (ieee || strings.Contains(fields[0], "-")) {
+			continue
+		}
+
+		prefix, bits := fields[0], 24
+		if i := strings.Index(prefix, "/"); i >= 0 {
+			switch prefix[i+1:] {
+			case "28":
+				bits = 28
+			case "36":
+				bits = 36
+			}
+			prefix = prefix[:i]
+		}
+		hex := strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(prefix))
+		if len(hex) < bits/4 || !isHex(hex) {
+			continue
+		}
+		hex = hex[:bits/4]
+
+		// IEEE oui.txt: "00-00-0C   (hex)\t\tCisco Systems, Inc"
+		vendor := strings.Join(fields[1:], " ")
+		vendor = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(vendor, "(hex)"), "(base 16)"))
+		// Wireshark manuf: "00:00:0C\tCisco\tCisco Systems, Inc", prefer the long name
+		if tabs := strings.Split(line, "\t"); len(tabs) >= 3 && strings.TrimSpace(tabs[2]) != "" {
+			vendor = strings.TrimSpace(tabs[2])
+		}
+		if vendor != "" {
+			ouiVendors[hex] = vendor
+		}
+	}
+	return scanner.Err()
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789ABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Look up the vendor of a MAC address, longest prefix first
+func macVendor(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return ""
+	}
+	hex := strings.ToUpper(strings.ReplaceAll(hw.String(), ":", ""))
+	for _, n := range []int{9, 7, 6} {
+		if vendor, ok := ouiVendors[hex[:n]]; ok {
+			return vendor
+		}
+	}
+	if hw[0]&0x02 != 0 {
+		return "Locally administered"
+	}
+	return ""
+}
+
+// Send a datagram to every host so the kernel resolves (ARPs) its MAC address
+func primeNeighbors(hosts []*HostResult) {
+	if len(hosts) == 0 {
+		return
+	}
+	for _, h := range hosts {
+		conn, err := net.Dial("udp", net.JoinHostPort(h.IP, "9"))
+		if err != nil {
+			continue
+		}
+		conn.Write([]byte{0})
+		conn.Close()
+	}
+	time.Sleep(300 * time.Millisecond)
+}
+
+var arpLine = regexp.MustCompile(`(?i)([0-9a-f:.]*[0-9][0-9a-f:.]*)\)?\s+(?:at\s+)?([0-9a-f]{1,2}(?:[:-][0-9a-f]{1,2}){5})`)
+
+// Read IP -> MAC pairs from the kernel neighbor tables (IPv4 and IPv6)
+func readNeighbors() map[string]string {
+	neighbors := readNeighbors6()
+
+	// Linux: /proc/net/arp has "IP HWtype Flags HWaddress Mask Device"
+	if data, err := os.ReadFile("/proc/net/arp"); err == nil {
+		lines := strings.Split(string(data), "\n")
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[2] == "0x0" || fields[3] == "00:00:00:00:00:00" {
+				continue
+			}
+			neighbors[fields[0]] = fields[3]
+		}
+		return neighbors
+	}
+
+	// Windows, macOS and BSD: parse "arp -a"
+	out, err := exec.Command("arp", "-a").Output()
+	if err != nil {
+		return neighbors
+	}
+	for _, m := range arpLine.FindAllStringSubmatch(string(out), -1) {
+		ip := strings.TrimPrefix(m[1], "(")
+		if hostIP(ip) == nil {
+			continue
+		}
+		if hw, err := net.ParseMAC(normalizeMAC(m[2])); err == nil {
+			neighbors[ip] = hw.String()
+		}
+	}
+	return neighbors
+}
+
+// macOS prints MACs without leading zeros ("0:1b:63:..."), Windows uses dashes
+func normalizeMAC(mac string) string {
+	parts := strings.FieldsFunc(mac, func(r rune) bool { return r == ':' || r == '-' })
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// Attach MAC addresses and vendors to discovered hosts, adding hosts inside
+// ipnet that only showed up in the neighbor table
+func resolveMACs(ipnet *net.IPNet, hosts []*HostResult) []*HostResult {
+	primeNeighbors(hosts)
+	neighbors := readNeighbors()
+
+	known := map[string]bool{}
+	for _, h := range hosts {
+		known[h.IP] = true
+		if mac, ok := neighbors[h.IP]; ok {
+			h.MAC = mac
+			h.Vendor = macVendor(mac)
+		}
+	}
+	for ip, mac := range neighbors {
+		if known[ip] || !ipnet.Contains(hostIP(ip)) {
+			continue
+		}
+		hosts = append(hosts, &HostResult{IP: ip, State: "up", MAC: mac, Vendor: macVendor(mac)})
+	}
+	return hosts
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOUI(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		prefix string // Expected key, empty when the line must be skipped
+		vendor string
+	}{
+		{"bundled", "00000C Cisco Systems, Inc", "00000C", "Cisco Systems, Inc"},
+		{"ieee hex", "00-00-0C   (hex)\t\tCisco Systems, Inc", "00000C", "Cisco Systems, Inc"},
+		{"ieee base 16", "00000C     (base 16)\t\tCisco Systems, Inc", "00000C", "Cisco Systems, Inc"},
+		{"manuf long name", "00:00:0C\tCisco\tCisco Systems, Inc", "00000C", "Cisco Systems, Inc"},
+		{"lower case", "aa:bb:cc Vendor", "AABBCC", "Vendor"},
+		{"28 bit block", "00:55:DA:50/28 Nanoleaf", "0055DA5", "Nanoleaf"},
+		{"36 bit block", "70:B3:D5:00:00/36 Some Vendor", "70B3D5000", "Some Vendor"},
+		{"28 bit block too short", "00:55:DA/28 Short", "", ""},
+		{"36 bit block too short", "70:B3:D5:00/36 Short", "", ""},
+		{"unknown mask", "00:11:22/20 Vendor", "001122", "Vendor"},
+		{"prefix too short", "00:11 Vendor", "", ""},
+		{"not hex", "GG:11:22 Vendor", "", ""},
+		{"no vendor", "001122", "", ""},
+		{"empty vendor", "00-11-22 (hex)", "", ""},
+		{"comment", "# 001122 Vendor", "", ""},
+		{"only slash", "/28 Vendor", "", ""},
+		{"postcode", "D-80333 Muenchen", "", ""},
+		{"dashed without (hex)", "AB-12-CD Street 5", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ouiVendors = map[string]string{}
+			if err := parseOUI(strings.NewReader(tt.line)); err != nil {
+				t.Fatalf("parseOUI: %v", err)
+			}
+			if tt.prefix == "" {
+				if len(ouiVendors) != 0 {
+					t.Fatalf("want line skipped, got %v", ouiVendors)
+				}
+				return
+			}
+			if got := ouiVendors[tt.prefix]; got != tt.vendor || len(ouiVendors) != 1 {
+				t.Fatalf("want %s=%q, got %v", tt.prefix, tt.vendor, ouiVendors)
+			}
+		})
+	}
+}
+
+func TestParseOUIRecords(t *testing.T) {
+	// An oui.txt excerpt whose address lines look like prefixes
+	text := "OUI/MA-L                                                    Organization\n" +
+		"company_id                                                  Organization\n" +
+		"\n" +
+		"00-00-0C   (hex)\t\tCisco Systems, Inc\n" +
+		"00000C     (base 16)\t\tCisco Systems, Inc\n" +
+		"\t\t\t\t80 West Tasman Drive\n" +
+		"\t\t\t\tSan Jose  CA  95134\n" +
+		"\t\t\t\tUS\n" +
+		"\n" +
+		"00-1B-2F   (hex)\t\tSome GmbH\n" +
+		"001B2F     (base 16)\t\tSome GmbH\n" +
+		"\t\t\t\tAB-12-CD Hauptstrasse\n" +
+		"\t\t\t\tD-80333 Muenchen\n" +
+		"\t\t\t\tBEEF42 Gebaeude 7\n" +
+		"\t\t\t\tDE\n"
+	ouiVendors = map[string]string{}
+	if err := parseOUI(strings.NewReader(text)); err != nil {
+		t.Fatalf("parseOUI: %v", err)
+	}
+	want := map[string]string{"00000C": "Cisco Systems, Inc", "001B2F": "Some GmbH"}
+	if len(ouiVendors) != len(want) {
+		t.Fatalf("want %v, got %v", want, ouiVendors)
+	}
+	for prefix, vendor := range want {
+		if ouiVendors[prefix] != vendor {
+			t.Fatalf("want %s=%q, got %v", prefix, vendor, ouiVendors)
+		}
+	}
+}
+
+func TestMacVendor(t *testing.T) {
+	ouiVendors = map[string]string{"00000C": "Cisco", "0055DA5": "Nanoleaf"}
+	tests := []struct {
+		mac, want string
+	}{
+		{"00:00:0c:12:34:56", "Cisco"},
+		{"00:55:da:51:00:01", "Nanoleaf"},
+		{"00:55:da:61:00:01", ""},
+		{"02:00:00:00:00:01", "Locally administered"},
+		{"not a mac", ""},
+	}
+	for _, tt := range tests {
+		if got := macVendor(tt.mac); got != tt.want {
+			t.Errorf("macVendor(%q) = %q, want %q", tt.mac, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names and services a host announced about itself on the local link
+type localNames struct {
+	Names    []string
+	Services []string
+	MAC      string // from NetBIOS node status
+}
+
+const localNameTimeout = time.Second
+
+func (n *localNames) addName(name string) {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, "."), ".local")
+	if name != "" && !contains(n.Names, name) {
+		n.Names = append(n.Names, name)
+	}
+}
+
+func (n *localNames) addService(service string) {
+	if service != "" && !contains(n.Services, service) {
+		n.Services = append(n.Services, service)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ask one host for its name via unicast mDNS, NetBIOS and LLMNR at the same time
+func lookupLocalNames(ip string) *localNames {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	names := &localNames{}
+
+	for _, query := range []func(string) *localNames{reverseMDNS, netbiosStatus, llmnrReverse} {
+		wg.Add(1)
+		go func(query func(string) *localNames) {
+			defer wg.Done()
+			found := query(ip)
+			if found == nil {
+				return
+			}
+			mu.Lock()
+			for _, name := range found.Names {
+				names.addName(name)
+			}
+			if found.MAC != "" {
+				names.MAC = found.MAC
+			}
+			mu.Unlock()
+		}(query)
+	}
+	wg.Wait()
+
+	// A NetBIOS status reply carries the MAC even when no name came back
+	if len(names.Names) == 0 && names.MAC == "" {
+		return nil
+	}
+	return names
+}
+
+// PTR query for the reverse name sent straight to the host's mDNS responder
+func reverseMDNS(ip string) *localNames {
+	return reversePTR(net.JoinHostPort(ip, "5353"), ip)
+}
+
+// LLMNR (RFC 4795) reverse query sent unicast to the host
+func llmnrReverse(ip string) *localNames {
+	return reversePTR(net.JoinHostPort(ip, "5355"), ip)
+}
+
+func reversePTR(addr, ip string) *localNames {
+	reply, err := udpQuery(addr, buildDNSQuery(0, encodeDNSName(reverseDNSName(ip)), dnsTypePTR), localNameTimeout)
+	if err != nil {
+		return nil
+	}
+	records, _ := parseDNSMessage(reply)
+	names := &localNames{}
+	for _, rec := range records {
+		if rec.Type == dnsTypePTR {
+			names.addName(rec.Target)
+		}
+	}
+	return names
+}
+
+// NetBIOS node status query (NBSTAT) on UDP 137
+func netbiosStatus(ip string) *localNames {
+	// The wildcard name "*" padded to 16 bytes, first-level encoded into 32 letters
+	raw := append([]byte{'*'}, make([]byte, 15)...)
+	encoded := []byte{32}
+	for _, b := range raw {
+		encoded = append(encoded, 'A'+b>>4, 'A'+b&0x0f)
+	}
+	encoded = append(encoded, 0)
+
+	reply, err := udpQuery(net.JoinHostPort(ip, "137"), buildDNSQuery(0x4e53, encoded, dnsTypeNB), localNameTimeout)
+	if err != nil {
+		return nil
+	}
+	records, _ := parseDNSMessage(reply)
+	names := &localNames{}
+	for _, rec := range records {
+		if rec.Type != dnsTypeNB || len(rec.Data) < 1 {
+			continue
+		}
+		count := int(rec.Data[0])
+		data := rec.Data[1:]
+		for i := 0; i < count && len(data) >= 18; i++ {
+			name := strings.TrimRight(string(data[:15]), " \x00")
+			suffix, flags := data[15], binary.BigEndian.Uint16(data[16:18])
+			// Unique workstation name (suffix 0x00, not a group name)
+			if suffix == 0x00 && flags&0x8000 == 0 {
+				names.addName(strings.ToLower(name))
+			}
+			data = data[18:]
+		}
+		if len(data) >= 6 && !isZero(data[:6]) {
+			names.MAC = net.HardwareAddr(data[:6]).String()
+		}
+	}
+	return names
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Browse DNS-SD over multicast DNS and collect what every responder advertises, by IP
+func browseMDNS(timeout time.Duration) map[string]*localNames {
+	found := map[string]*localNames{}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return found
+	}
+	defer conn.Close()
+
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	asked := map[string]bool{}
+	ask := func(name string) {
+		if asked[name] {
+			return
+		}
+		asked[name] = true
+		conn.WriteToUDP(buildDNSQuery(0, encodeDNSName(name), dnsTypePTR), group)
+	}
+	ask("_services._dns-sd._udp.local")
+
+	get := func(ip string) *localNames {
+		if found[ip] == nil {
+			found[ip] = &localNames{}
+		}
+		return found[ip]
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		records, _ := parseDNSMessage(buf[:n])
+		host := get(from.IP.String())
+		for _, rec := range records {
+			switch {
+			case rec.Type == dnsTypePTR && rec.Name == "_services._dns-sd._udp.local":
+				ask(rec.Target)
+			case rec.Type == dnsTypePTR && strings.HasSuffix(rec.Name, ".local") && strings.HasPrefix(rec.Name, "_"):
+				// "_ipp._tcp.local" -> instance "printer-3f._ipp._tcp.local"
+				host.addService(strings.TrimSuffix(rec.Name, ".local"))
+			case rec.Type == dnsTypeSRV:
+				host.addName(rec.Target)
+			case rec.Type == dnsTypeA && rec.IP != nil:
+				get(rec.IP.String()).addName(rec.Name)
+			}
+		}
+	}
+	for ip, names := range found {
+		if len(names.Names) == 0 && len(names.Services) == 0 {
+			delete(found, ip)
+		}
+		sort.Strings(names.Services)
+	}
+	return found
+}
+
+// Merge local names into the host list, adding responders inside ipnet that were not found yet
+func mergeLocalNames(ipnet *net.IPNet, hosts []*HostResult, perHost map[string]*localNames, mdns map[string]*localNames) []*HostResult {
+	byIP := map[string]*HostResult{}
+	for _, h := range hosts {
+		byIP[h.IP] = h
+	}
+	merge := func(ip string, names *localNames) {
+		h, ok := byIP[ip]
+		if !ok {
+			if !ipnet.Contains(hostIP(ip)) {
+				return
+			}
+			h = &HostResult{IP: ip, State: "up"}
+			byIP[ip] = h
+			hosts = append(hosts, h)
+		}
+		for _, name := range names.Names {
+			if !contains(h.Hostnames, name) {
+				h.Hostnames = append(h.Hostnames, name)
+			}
+		}
+		for _, service := range names.Services {
+			if !contains(h.Services, service) {
+				h.Services = append(h.Services, service)
+			}
+		}
+		if h.MAC == "" && names.MAC != "" {
+			h.MAC = names.MAC
+			h.Vendor = macVendor(names.MAC)
+		}
+	}
+	for ip, names := range perHost {
+		merge(ip, names)
+	}
+	for ip, names := range mdns {
+		merge(ip, names)
+	}
+	return hosts
+}
@@ -1,104 +1,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/urfave/cli/v2"
 )
 
-var portDescriptions = map[int]string{
-	// Well-known ports (0–1023)
-	20:   "FTP Data Transfer",
-	21:   "FTP Control",
-	22:   "SSH Remote Login",
-	23:   "Telnet",
-	25:   "SMTP Email Routing",
-	53:   "DNS (Domain Name System)",
-	67:   "DHCP (Server)",
-	68:   "DHCP (Client)",
-	69:   "TFTP (Trivial File Transfer Protocol)",
-	80:   "HTTP (Hypertext Transfer Protocol)",
-	110:  "POP3 (Post Office Protocol)",
-	119:  "NNTP (Network News Transfer Protocol)",
-	123:  "NTP (Network Time Protocol)",
-	135:  "RPC (Remote Procedure Call)",
-	143:  "IMAP (Internet Message Access Protocol)",
-	161:  "SNMP (Simple Network Management Protocol)",
-	194:  "IRC (Internet Relay Chat)",
-	389:  "LDAP (Lightweight Directory Access Protocol)",
-	443:  "HTTPS (HTTP Secure)",
-	445:  "Microsoft-DS (Active Directory, SMB)",
-	465:  "SMTPS (Simple Mail Transfer Protocol Secure)",
-	500:  "ISAKMP (VPN)",
-	514:  "Syslog",
-	520:  "RIP (Routing Information Protocol)",
-	546:  "DHCPv6 Client",
-	547:  "DHCPv6 Server",
-	587:  "SMTP (Mail Submission)",
-	631:  "IPP (Internet Printing Protocol)",
-	993:  "IMAPS (Secure IMAP)",
-	995:  "POP3S (Secure POP3)",
-	1433: "Microsoft SQL Server",
-	1434: "Microsoft SQL Monitor",
-	1521: "Oracle Database",
-	1701: "L2TP (Layer 2 Tunneling Protocol)",
-	1723: "PPTP (Point-to-Point Tunneling Protocol)",
-	1812: "RADIUS Authentication",
-	1813: "RADIUS Accounting",
-	2049: "NFS (Network File System)",
-	2082: "cPanel (Web Hosting Management)",
-	2083: "cPanel (Web Hosting Secure)",
-	2100: "Oracle XDB FTP",
-	2222: "DirectAdmin (Control Panel)",
-	2483: "Oracle DB listener",
-	2484: "Oracle DB listener (Secure)",
-	3306: "MySQL Database",
-	3389: "Microsoft RDP (Remote Desktop Protocol)",
-	3690: "Subversion (SVN)",
-	4444: "Metasploit RPC Server",
-	4567: "CruiseControl",
-	4662: "eMule (P2P file sharing)",
-	5432: "PostgreSQL Database",
-	5900: "VNC (Virtual Network Computing)",
-	6379: "Redis",
-	6660: "IRC (Internet Relay Chat)",
-	6881: "BitTorrent (P2P File Sharing)",
-	8080: "HTTP Proxy/Alternative HTTP",
-	8443: "HTTPS Alternative",
-	8888: "HTTP Alternative",
-	9000: "SonarQube",
-	9090: "Openfire Administration Console",
-	9200: "Elasticsearch",
-	11211: "Memcached",
-	27017: "MongoDB",
-	27018: "MongoDB (Replica Set) (Secondary)",
-	27019: "MongoDB (Shard) (Arbiter)",
-	50000: "SAP Management Console",
-	50030: "Hadoop JobTracker",
-	50070: "Hadoop NameNode",
-	54321: "BOINC (Distributed Computing)",
-	55000: "Veeam Backup",
-	636:  "LDAPS (Secure LDAP)",
-	873:  "Rsync",
-	902:  "VMware Server Console",
-	1080: "SOCKS Proxy",
-	1194: "OpenVPN",
-	3260: "iSCSI (Internet Small Computer System Interface)",
-}
-
 func main() {
 	app := &cli.App{
 		Name:  "netshell",
 		Usage: "Enhanced network scanning tool with CIDR support",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "on",
-				Usage:    "CIDR notation of the network to scan (e.g., 192.168.0.0/24)",
-				Required: true,
+				Name:  "on",
+				Usage: "CIDR notation of the network to scan (e.g., 192.168.0.0/24)",
 			},
 			&cli.BoolFlag{
 				Name:  "listusers",
@@ -113,25 +36,169 @@ func main() {
 				Usage: "Set the range of ports to scan (use with --scanports)",
 				Value: 1024,
 			},
+			&cli.IntFlag{
+				Name:  "top-ports",
+				Usage: "Scan the N most frequently open TCP ports of the service database instead of --portrange",
+			},
+			&cli.StringFlag{
+				Name:  "services",
+				Usage: "Additional nmap-services or /etc/services style file overriding the bundled service database",
+			},
+			&cli.StringFlag{
+				Name:  "checkpoint",
+				Usage: "Periodically save scan progress to this file; Ctrl-C saves it too",
+			},
+			&cli.DurationFlag{
+				Name:  "checkpoint-interval",
+				Usage: "How often to write the checkpoint file",
+				Value: 30 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Usage: "Continue the interrupted scan saved in --checkpoint without probing finished ports again",
+			},
+			&cli.StringFlag{
+				Name:  "progress",
+				Usage: "Progress reporting: auto (a status line on a terminal, JSON events otherwise), line, json or off",
+				Value: "auto",
+			},
+			&cli.DurationFlag{
+				Name:  "progress-interval",
+				Usage: "How often JSON progress events are written",
+				Value: 10 * time.Second,
+			},
+			&cli.StringSliceFlag{
+				Name:  "proxy",
+				Usage: "Make TCP connections to scanned hosts through this proxy: socks5://[user:pass@]host:port, http://... or https://...; repeat for a chain, first hop first",
+			},
+			&cli.DurationFlag{
+				Name:  "proxy-timeout",
+				Usage: "Time allowed for a connection through the proxy chain",
+				Value: 5 * time.Second,
+			},
+			&cli.IntFlag{
+				Name:  "proxy-concurrency",
+				Usage: "Ports probed at once per host when going through a proxy",
+				Value: 64,
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Report format: text, json, xml (nmap compatible) or grep",
+				Value: "text",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Usage: "Write the report to a file instead of stdout",
+			},
+			&cli.IntFlag{
+				Name:  "ipv6-max-prefix",
+				Usage: "Only try every address of IPv6 prefixes at least this long; larger ones use multicast and neighbor discovery",
+				Value: 120,
+			},
+			&cli.BoolFlag{
+				Name:  "localnames",
+				Usage: "Also ask every address for its name and services via mDNS, NetBIOS and LLMNR; adds up to a second per address and only answers on the local link",
+			},
+			&cli.BoolFlag{
+				Name:  "http",
+				Usage: "Fetch / and well-known paths from open ports that speak HTTP(S) (use with --scanports)",
+			},
+			&cli.BoolFlag{
+				Name:  "ssh",
+				Usage: "Record version, algorithms and host key fingerprints of SSH servers (use with --scanports)",
+			},
+			&cli.StringFlag{
+				Name:  "checks",
+				Usage: "Run checks on matching open ports: \"all\" or a comma separated list (" + strings.Join(checkNames(), ", ") + ")",
+			},
+			&cli.BoolFlag{
+				Name:  "osdetect",
+				Usage: "Guess the OS of each host from TTL, TCP window and options (best with --scanports, needs root for packet capture)",
+			},
+			&cli.StringFlag{
+				Name:  "oui",
+				Usage: "MAC vendor database (IEEE oui.txt or Wireshark manuf) instead of the bundled one",
+			},
+			&cli.StringFlag{
+				Name:  "compare",
+				Usage: "Compare with a previous JSON report and print new/disappeared hosts and opened/closed ports",
+			},
+			&cli.DurationFlag{
+				Name:  "watch",
+				Usage: "Rescan on this interval (e.g. 24h) and report only changes",
+			},
+			&cli.StringFlag{
+				Name:  "watch-log",
+				Usage: "Append changes found in watch mode to this file",
+			},
+			&cli.StringFlag{
+				Name:  "webhook",
+				Usage: "POST changes found in watch mode as JSON to this URL",
+			},
 		},
+		Commands: append(netcatCommands(), shellCommand()),
 		Action: func(c *cli.Context) error {
-			cidr := c.String("on")
-			devices := []string{}
+			if c.String("on") == "" {
+				return fmt.Errorf("--on is required when scanning")
+			}
+			if err := loadOUI(c.String("oui")); err != nil {
+				return err
+			}
+			if err := loadServices(c.String("services")); err != nil {
+				return err
+			}
+			if err := configureProxies(c.StringSlice("proxy"), c.Duration("proxy-timeout"), c.Int("proxy-concurrency")); err != nil {
+				return err
+			}
+			opts, err := scanOptionsFrom(c)
+			if err != nil {
+				return err
+			}
+
+			var baseline *ScanResult
+			if path := c.String("compare"); path != "" {
+				if baseline, err = loadReport(path); err != nil {
+					return err
+				}
+			}
 
-			if c.Bool("listusers") {
-				fmt.Printf("Scanning %s for devices!\n", cidr)
-				devices = listConnectedDevices(cidr)
+			if interval := c.Duration("watch"); interval > 0 {
+				sinks := watchSinks{logPath: c.String("watch-log"), webhook: c.String("webhook")}
+				return watchScans(interval, baseline, func() *ScanResult {
+					result := runScan(opts, nil)
+					if path := c.String("output"); path != "" {
+						if err := saveReport(path, result, c.String("format")); err != nil {
+							fmt.Fprintln(os.Stderr, "Error saving report:", err)
+						}
+					}
+					return result
+				}, sinks)
 			}
 
-			if c.Bool("scanports") {
-				if len(devices) == 0 {
-					devices = listConnectedDevices(cidr)
+			var resumed *scanState
+			if c.Bool("resume") {
+				path := c.String("checkpoint")
+				if path == "" {
+					return fmt.Errorf("--resume needs the --checkpoint file of the interrupted scan")
 				}
-				for _, device := range devices {
-					scanOpenPorts(device, c.Int("portrange"))
+				if resumed, err = loadScanState(path); err != nil {
+					return err
+				}
+				if resumed.result.Target != c.String("on") {
+					return fmt.Errorf("%s is a checkpoint for %s, not %s", path, resumed.result.Target, c.String("on"))
 				}
 			}
-			return nil
+
+			result := runScan(opts, resumed)
+			if baseline != nil {
+				if err := writeDiff(os.Stdout, diffResults(baseline, result)); err != nil {
+					return err
+				}
+				if c.String("output") == "" {
+					return nil
+				}
+			}
+			return saveReport(c.String("output"), result, c.String("format"))
 		},
 	}
 
@@ -142,36 +209,251 @@ func main() {
 	}
 }
 
-func listConnectedDevices(cidr string) []string {
+// What a scan run does, from the command line or the interactive shell
+type scanOptions struct {
+	target             string
+	args               string
+	discover           bool
+	scanPorts          bool
+	ports              []int
+	localNames         bool
+	ipv6MaxPrefix      int
+	osDetect           bool
+	http               bool
+	ssh                bool
+	checks             []ScanCheck
+	checkpoint         string
+	checkpointInterval time.Duration
+	progress           string
+	progressInterval   time.Duration
+}
+
+func scanOptionsFrom(c *cli.Context) (scanOptions, error) {
+	opts := scanOptions{
+		target:             c.String("on"),
+		args:               strings.Join(os.Args, " "),
+		discover:           c.Bool("listusers") || c.Bool("scanports") || c.Bool("osdetect"),
+		scanPorts:          c.Bool("scanports"),
+		localNames:         c.Bool("localnames"),
+		ipv6MaxPrefix:      c.Int("ipv6-max-prefix"),
+		osDetect:           c.Bool("osdetect"),
+		http:               c.Bool("http"),
+		ssh:                c.Bool("ssh"),
+		checkpoint:         c.String("checkpoint"),
+		checkpointInterval: c.Duration("checkpoint-interval"),
+		progress:           c.String("progress"),
+		progressInterval:   c.Duration("progress-interval"),
+	}
+	switch opts.progress {
+	case "auto", "line", "json", "off":
+	default:
+		return opts, fmt.Errorf("unknown progress mode %q (auto, line, json or off)", opts.progress)
+	}
+	if n := c.Int("top-ports"); n > 0 {
+		opts.ports = topPorts(n, "tcp")
+	} else {
+		ports, err := portRange(c.Int("portrange"))
+		if err != nil {
+			return opts, err
+		}
+		opts.ports = ports
+	}
+	if spec := c.String("checks"); spec != "" {
+		checks, err := selectChecks(spec)
+		if err != nil {
+			return opts, err
+		}
+		opts.checks = checks
+	}
+	return opts, nil
+}
+
+// Run one discovery/port scan.
+// resumed continues an interrupted scan loaded from a checkpoint.
+func runScan(opts scanOptions, resumed *scanState) *ScanResult {
+	cidr := opts.target
+	result := &ScanResult{
+		Target:  cidr,
+		Args:    opts.args,
+		Started: time.Now(),
+	}
+	ports := opts.ports
+
+	state := resumed
+	if state != nil {
+		result, ports = state.result, state.ports
+		fmt.Fprintf(os.Stderr, "Resuming scan of %s from %s\n", cidr, state.path)
+	} else if opts.checkpoint != "" {
+		state = newScanState(opts.checkpoint, result, ports)
+	}
+	stopAutosave := func() {}
+	if state != nil {
+		stopAutosave = state.autosave(opts.checkpointInterval)
+	}
+	// Guards the host results while autosave may be writing them out
+	results := sync.Locker(&sync.Mutex{})
+	if state != nil {
+		results = &state.mu
+	}
+	stopProgress := reportProgress(cidr, opts.progress, opts.progressInterval)
+	defer stopProgress()
+
+	if (state == nil || !state.discovered) && opts.discover {
+		fmt.Fprintf(os.Stderr, "Scanning %s for devices!\n", cidr)
+		hosts := listConnectedDevices(cidr, opts.localNames, opts.ipv6MaxPrefix)
+		if state != nil {
+			state.setDiscovered(hosts)
+		} else {
+			result.Hosts = hosts
+		}
+	}
+
+	scanPorts := func() {
+		if !opts.scanPorts {
+			return
+		}
+		total := len(result.Hosts) * len(ports)
+		if state != nil {
+			total = 0
+			for _, host := range result.Hosts {
+				total += len(state.remaining(host.IP))
+			}
+		}
+		progress.begin("ports", len(result.Hosts), total)
+		for _, host := range result.Hosts {
+			if state == nil {
+				host.Ports = scanOpenPorts(host.IP, ports)
+			} else {
+				probePorts(host.IP, state.remaining(host.IP), func(port int, open *PortResult) {
+					state.markPort(host, port, open)
+				})
+			}
+			progress.hostDone()
+		}
+	}
+	if opts.osDetect {
+		fingerprintHosts(result.Hosts, results, scanPorts)
+	} else {
+		scanPorts()
+	}
+
+	if opts.http {
+		progress.begin("http", len(result.Hosts), 0)
+		enumerateWebPorts(result.Hosts, results)
+	}
+	if opts.ssh {
+		progress.begin("ssh", len(result.Hosts), 0)
+		inventorySSHPorts(result.Hosts, results)
+	}
+	if len(opts.checks) > 0 {
+		progress.begin("checks", len(result.Hosts), 0)
+		runChecks(result.Hosts, opts.checks, results)
+	}
+	if state != nil {
+		stopAutosave()
+		state.remove()
+	}
+
+	result.Finished = time.Now()
+	return result
+}
+
+// Addresses probed at once during discovery. A probe holds a socket for its
+// reverse DNS lookup, then three for local names, so this bounds the file
+// descriptors and goroutines a large range needs.
+const discoveryConcurrency = 512
+
+func listConnectedDevices(cidr string, withLocalNames bool, maxIPv6Prefix int) []*HostResult {
 	ip, ipnet, err := net.ParseCIDR(cidr)
 	if err != nil {
-		fmt.Println("Invalid CIDR notation:", err)
+		fmt.Fprintln(os.Stderr, "Invalid CIDR notation:", err)
 		return nil
 	}
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	devices := []string{}
+	hosts := []*HostResult{}
+	perHost := map[string]*localNames{}
 
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-		wg.Add(1)
-		go func(ip string) {
-			defer wg.Done()
-			addr, err := net.LookupAddr(ip)
-			if err == nil {
+	mdns := make(chan map[string]*localNames, 1)
+	if withLocalNames {
+		go func() { mdns <- browseMDNS(2 * time.Second) }()
+	} else {
+		mdns <- nil
+	}
+
+	slots := make(chan struct{}, discoveryConcurrency)
+	probe := func(ip string) {
+		defer func() { <-slots }()
+		defer wg.Done()
+		defer progress.hostDone()
+		defer progress.probed(false)
+		addr, err := net.LookupAddr(ip)
+		if err == nil {
+			host := &HostResult{IP: ip, State: "up"}
+			for _, name := range addr {
+				host.Hostnames = append(host.Hostnames, strings.TrimSuffix(name, "."))
+			}
+			mu.Lock()
+			hosts = append(hosts, host)
+			mu.Unlock()
+		}
+		if withLocalNames {
+			if names := lookupLocalNames(ip); names != nil {
 				mu.Lock()
-				devices = append(devices, ip)
+				perHost[ip] = names
 				mu.Unlock()
-				if len(addr) > 0 {
-					fmt.Printf("* %s (%s)\n", ip, addr[0])
-				} else {
-					fmt.Printf("* %s\n", ip)
-				}
 			}
-		}(ip.String())
+		}
+	}
+
+	if canBruteForce(ipnet, maxIPv6Prefix) {
+		ones, bits := ipnet.Mask.Size()
+		addresses := 1 << min(bits-ones, 62)
+		progress.begin("discovery", addresses, addresses)
+		for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
+			slots <- struct{}{}
+			wg.Add(1)
+			go probe(ip.String())
+		}
+	} else {
+		explainIPv6Discovery(ipnet, maxIPv6Prefix)
+		progress.begin("discovery", 0, 0)
+		found := discoverIPv6(ipnet)
+		progress.setTotals(len(found), len(found))
+		for _, addr := range found {
+			// Found by discovery, so up even without a PTR record
+			mu.Lock()
+			hosts = append(hosts, &HostResult{IP: addr, State: "up"})
+			mu.Unlock()
+			slots <- struct{}{}
+			wg.Add(1)
+			go probe(addr)
+		}
 	}
 	wg.Wait()
-	return devices
+	hosts = mergeLocalNames(ipnet, hosts, perHost, <-mdns)
+	return resolveMACs(ipnet, dedupeHosts(hosts))
+}
+
+// Merge host entries that were found more than once
+func dedupeHosts(hosts []*HostResult) []*HostResult {
+	byIP := map[string]*HostResult{}
+	var result []*HostResult
+	for _, h := range hosts {
+		prev, ok := byIP[h.IP]
+		if !ok {
+			byIP[h.IP] = h
+			result = append(result, h)
+			continue
+		}
+		for _, name := range h.Hostnames {
+			if !contains(prev.Hostnames, name) {
+				prev.Hostnames = append(prev.Hostnames, name)
+			}
+		}
+	}
+	return result
 }
 
 func incrementIP(ip net.IP) {
@@ -183,23 +465,77 @@ func incrementIP(ip net.IP) {
 	}
 }
 
-func scanOpenPorts(ip string, portRange int) {
+func scanOpenPorts(ip string, portList []int) []*PortResult {
+	var mu sync.Mutex
+	ports := []*PortResult{}
+	probePorts(ip, portList, func(port int, open *PortResult) {
+		if open != nil {
+			mu.Lock()
+			ports = append(ports, open)
+			mu.Unlock()
+		}
+	})
+	return ports
+}
+
+// Try every port and report each one to probed, with a result only when it is
+// open. Ports that could not be tried because the proxy chain failed are not
+// reported, so a resumed scan tries them again.
+func probePorts(ip string, portList []int, probed func(port int, open *PortResult)) {
 	var wg sync.WaitGroup
-	for port := 1; port <= portRange; port++ {
+	var slots chan struct{}
+	if len(proxyChain) > 0 {
+		slots = make(chan struct{}, proxyConcurrency)
+	}
+	for _, port := range portList {
 		wg.Add(1)
 		go func(port int) {
 			defer wg.Done()
-			address := fmt.Sprintf("%s:%d", ip, port)
-			conn, err := net.DialTimeout("tcp", address, 500*time.Millisecond)
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			address := net.JoinHostPort(ip, strconv.Itoa(port))
+			conn, err := dialTCP(address, 500*time.Millisecond)
+			var perr *proxyError
+			if errors.As(err, &perr) && perr.state == "" {
+				reportProxyFailure(perr)
+				progress.probed(false)
+				return
+			}
 			if err == nil {
+				banner := grabBanner(conn)
 				conn.Close()
-				portUse := "Unknown"
-				if description, ok := portDescriptions[port]; ok {
-					portUse = description
-				}
-				fmt.Printf("Port %d open on %s (%s)\n", port, ip, portUse)
+				service := lookupService(port, "tcp")
+				progress.probed(true)
+				probed(port, &PortResult{Port: port, Protocol: "tcp", State: "open", Service: service.Name, Description: service.Description, Banner: banner})
+				return
 			}
+			progress.probed(false)
+			probed(port, nil)
 		}(port)
 	}
 	wg.Wait()
 }
+
+// Read whatever the service sends first, for services that greet on connect
+func grabBanner(conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	buf := make([]byte, 256)
+	n, _ := conn.Read(buf)
+	return sanitizeBanner(buf[:n])
+}
+
+// Keep the first line of a banner and drop non-printable bytes
+func sanitizeBanner(data []byte) string {
+	line := string(data)
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, line)
+}
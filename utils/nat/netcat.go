@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Flags shared by the netcat style commands
+var netcatFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:    "udp",
+		Aliases: []string{"u"},
+		Usage:   "Use UDP instead of TCP",
+	},
+	&cli.DurationFlag{
+		Name:    "idle-timeout",
+		Aliases: []string{"w"},
+		Usage:   "Close connections after this long without traffic (0 disables)",
+	},
+	&cli.DurationFlag{
+		Name:  "connect-timeout",
+		Usage: "Give up connecting to the remote host after this long",
+		Value: 10 * time.Second,
+	},
+}
+
+func netcatCommands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:      "connect",
+			Usage:     "Connect to host:port and pipe stdin/stdout over it",
+			ArgsUsage: "host:port",
+			Flags:     netcatFlags,
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("usage: connect host:port")
+				}
+				conn, err := net.DialTimeout(netcatNetwork(c), c.Args().First(), c.Duration("connect-timeout"))
+				if err != nil {
+					return err
+				}
+				pipeStdio(conn, newStdinFeed(), c.Duration("idle-timeout"))
+				return nil
+			},
+		},
+		{
+			Name:      "listen",
+			Usage:     "Listen on [host]:port and pipe the first connection to stdin/stdout",
+			ArgsUsage: "[host]:port",
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:    "keep",
+					Aliases: []string{"k"},
+					Usage:   "Keep listening for new connections after the client disconnects",
+				},
+			}, netcatFlags...),
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("usage: listen [host]:port")
+				}
+				if c.Bool("udp") {
+					return listenUDP(c.Args().First(), c.Duration("idle-timeout"))
+				}
+				return listenTCP(c.Args().First(), c.Bool("keep"), c.Duration("idle-timeout"))
+			},
+		},
+		{
+			Name:      "forward",
+			Usage:     "Relay connections accepted on [host]:port to a remote host:port",
+			ArgsUsage: "[host]:port host:port",
+			Flags:     netcatFlags,
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 2 {
+					return fmt.Errorf("usage: forward [host]:port host:port")
+				}
+				if c.Bool("udp") {
+					return forwardUDP(c.Args().Get(0), c.Args().Get(1), c.Duration("idle-timeout"))
+				}
+				return forwardTCP(c.Args().Get(0), c.Args().Get(1), c.Duration("idle-timeout"), c.Duration("connect-timeout"))
+			},
+		},
+	}
+}
+
+func netcatNetwork(c *cli.Context) string {
+	if c.Bool("udp") {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// Read stdin once in the background so successive connections can share it.
+// The channel is closed on EOF.
+func readStdin() <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				ch <- buf[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Stdin shared by the connections of one listener, read by a single
+// goroutine. Data taken for a connection that closed before it was sent is
+// kept for the next connection instead of being lost.
+type stdinFeed struct {
+	ch      <-chan []byte
+	pending []byte
+}
+
+func newStdinFeed() *stdinFeed {
+	return &stdinFeed{ch: readStdin()}
+}
+
+// Next chunk of stdin; ok is false once stdin is closed. Only one connection
+// reads at a time, so pending needs no lock.
+func (f *stdinFeed) next(done <-chan struct{}) (data []byte, ok, stopped bool) {
+	if data = f.pending; data != nil {
+		f.pending = nil
+		return data, true, false
+	}
+	select {
+	case data, ok = <-f.ch:
+		return data, ok, false
+	case <-done:
+		return nil, true, true
+	}
+}
+
+// Keep data for the next connection
+func (f *stdinFeed) unread(data []byte) {
+	f.pending = data
+}
+
+// Connection that pushes its deadline forward on every read and write
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func withIdleTimeout(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout <= 0 {
+		return conn
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	return &idleConn{Conn: conn, timeout: timeout}
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return n, err
+}
+
+// Writer that counts the bytes passing through it
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Close the write half of a connection so the peer sees EOF
+func closeWrite(conn net.Conn) {
+	if ic, ok := conn.(*idleConn); ok {
+		conn = ic.Conn
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// Pipe stdin to the connection and the connection to stdout until the peer closes
+func pipeStdio(raw net.Conn, stdin *stdinFeed, idle time.Duration) {
+	conn := withIdleTimeout(raw, idle)
+	defer conn.Close()
+
+	sent := &countingWriter{w: conn}
+	received := &countingWriter{w: os.Stdout}
+	done := make(chan struct{})
+	copied := make(chan struct{})
+
+	go func() {
+		defer close(copied)
+		for {
+			data, ok, stopped := stdin.next(done)
+			if stopped {
+				return
+			}
+			if !ok {
+				closeWrite(conn)
+				return
+			}
+			select {
+			case <-done:
+				// The peer is gone, leave the data for the next one
+				stdin.unread(data)
+				return
+			default:
+			}
+			if n, err := sent.Write(data); err != nil {
+				stdin.unread(data[n:])
+				return
+			}
+		}
+	}()
+
+	io.Copy(received, conn)
+	close(done)
+	// Interrupt a blocked write; the next connection may only read stdin
+	// once this one has stopped
+	conn.SetWriteDeadline(time.Now())
+	<-copied
+	fmt.Fprintf(os.Stderr, "Closed %s: sent %d bytes, received %d bytes\n",
+		raw.RemoteAddr(), sent.n.Load(), received.n.Load())
+}
+
+func listenTCP(addr string, keep bool, idle time.Duration) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", listener.Addr())
+
+	stdin := newStdinFeed()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Connection from %s\n", conn.RemoteAddr())
+		pipeStdio(conn, stdin, idle)
+		if !keep {
+			return nil
+		}
+	}
+}
+
+// UDP has no connections: print every datagram and send stdin to the last peer heard from
+func listenUDP(addr string, idle time.Duration) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	fmt.Fprintf(os.Stderr, "Listening on %s/udp\n", pc.LocalAddr())
+
+	var mu sync.Mutex
+	var peer net.Addr
+	var sent, received int64
+
+	go func() {
+		for data := range readStdin() {
+			mu.Lock()
+			to := peer
+			mu.Unlock()
+			if to == nil {
+				continue
+			}
+			if n, err := pc.WriteTo(data, to); err == nil {
+				atomic.AddInt64(&sent, int64(n))
+			}
+		}
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		if idle > 0 {
+			pc.SetReadDeadline(time.Now().Add(idle))
+		}
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Closed %s/udp: sent %d bytes, received %d bytes\n",
+				pc.LocalAddr(), atomic.LoadInt64(&sent), atomic.LoadInt64(&received))
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil
+			}
+			return err
+		}
+		mu.Lock()
+		peer = from
+		mu.Unlock()
+		atomic.AddInt64(&received, int64(n))
+		os.Stdout.Write(buf[:n])
+	}
+}
+
+func forwardTCP(listenAddr, remoteAddr string, idle, timeout time.Duration) error {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Fprintf(os.Stderr, "Forwarding %s -> %s\n", listener.Addr(), remoteAddr)
+
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func(client net.Conn) {
+			remote, err := net.DialTimeout("tcp", remoteAddr, timeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error connecting %s to %s: %v\n", client.RemoteAddr(), remoteAddr, err)
+				client.Close()
+				return
+			}
+			relay(client, remote, idle)
+		}(client)
+	}
+}
+
+// Copy both directions concurrently until each side has finished sending
+func relay(clientRaw, remoteRaw net.Conn, idle time.Duration) {
+	client := withIdleTimeout(clientRaw, idle)
+	remote := withIdleTimeout(remoteRaw, idle)
+	defer client.Close()
+	defer remote.Close()
+
+	up := &countingWriter{w: remote}
+	down := &countingWriter{w: client}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(up, client)
+		closeWrite(remote)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(down, remote)
+		closeWrite(client)
+	}()
+	wg.Wait()
+
+	fmt.Fprintf(os.Stderr, "Closed %s <-> %s: sent %d bytes, received %d bytes\n",
+		clientRaw.RemoteAddr(), remoteRaw.RemoteAddr(), up.n.Load(), down.n.Load())
+}
+
+// Relay datagrams, keeping one upstream socket per client address
+func forwardUDP(listenAddr, remoteAddr string, idle time.Duration) error {
+	pc, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	fmt.Fprintf(os.Stderr, "Forwarding %s/udp -> %s\n", pc.LocalAddr(), remoteAddr)
+
+	if idle <= 0 {
+		idle = 2 * time.Minute
+	}
+
+	var mu sync.Mutex
+	sessions := make(map[string]net.Conn)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		upstream, ok := sessions[from.String()]
+		if !ok {
+			upstream, err = net.Dial("udp", remoteAddr)
+			if err != nil {
+				mu.Unlock()
+				fmt.Fprintf(os.Stderr, "Error connecting %s to %s: %v\n", from, remoteAddr, err)
+				continue
+			}
+			sessions[from.String()] = upstream
+			go func(from net.Addr, upstream net.Conn) {
+				var received int64
+				reply := make([]byte, 64*1024)
+				for {
+					upstream.SetReadDeadline(time.Now().Add(idle))
+					n, err := upstream.Read(reply)
+					if err != nil {
+						break
+					}
+					received += int64(n)
+					pc.WriteTo(reply[:n], from)
+				}
+				mu.Lock()
+				delete(sessions, from.String())
+				mu.Unlock()
+				upstream.Close()
+				fmt.Fprintf(os.Stderr, "Closed %s <-> %s/udp: received %d bytes\n", from, remoteAddr, received)
+			}(from, upstream)
+		}
+		mu.Unlock()
+
+		upstream.SetReadDeadline(time.Now().Add(idle))
+		upstream.Write(buf[:n])
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters of the running scan, updated by discovery and the port scanner
+type scanProgress struct {
+	mu          sync.Mutex
+	target      string
+	phase       string
+	phaseStart  time.Time
+	hostsTotal  int64
+	probesTotal int64
+
+	hostsDone atomic.Int64
+	probes    atomic.Int64
+	open      atomic.Int64
+}
+
+var progress = &scanProgress{}
+
+// A snapshot of the progress, also the JSON status event
+type progressStatus struct {
+	Event       string  `json:"event"`
+	Time        string  `json:"time"`
+	Target      string  `json:"target"`
+	Phase       string  `json:"phase"`
+	HostsDone   int64   `json:"hosts_done"`
+	HostsTotal  int64   `json:"hosts_total"`
+	Probes      int64   `json:"probes"`
+	ProbesTotal int64   `json:"probes_total"`
+	Rate        float64 `json:"rate"`
+	OpenPorts   int64   `json:"open_ports"`
+	Elapsed     float64 `json:"elapsed_seconds"`
+	ETA         float64 `json:"eta_seconds,omitempty"`
+}
+
+// Start a phase ("discovery", "ports", "http", ...) with its expected totals
+func (p *scanProgress) begin(phase string, hosts, probes int) {
+	p.mu.Lock()
+	p.phase, p.phaseStart = phase, time.Now()
+	p.hostsTotal, p.probesTotal = int64(hosts), int64(probes)
+	p.mu.Unlock()
+	p.hostsDone.Store(0)
+	p.probes.Store(0)
+}
+
+// Adjust the totals once they are known, e.g. after IPv6 neighbor discovery
+func (p *scanProgress) setTotals(hosts, probes int) {
+	p.mu.Lock()
+	p.hostsTotal, p.probesTotal = int64(hosts), int64(probes)
+	p.mu.Unlock()
+}
+
+func (p *scanProgress) hostDone() {
+	p.hostsDone.Add(1)
+}
+
+func (p *scanProgress) probed(open bool) {
+	p.probes.Add(1)
+	if open {
+		p.open.Add(1)
+	}
+}
+
+func (p *scanProgress) status(event string) progressStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := progressStatus{
+		Event:       event,
+		Time:        time.Now().Format(time.RFC3339),
+		Target:      p.target,
+		Phase:       p.phase,
+		HostsDone:   p.hostsDone.Load(),
+		HostsTotal:  p.hostsTotal,
+		Probes:      p.probes.Load(),
+		ProbesTotal: p.probesTotal,
+		OpenPorts:   p.open.Load(),
+		Elapsed:     time.Since(p.phaseStart).Seconds(),
+	}
+	if s.Elapsed > 0 {
+		s.Rate = float64(s.Probes) / s.Elapsed
+	}
+	if s.Rate > 0 && s.ProbesTotal > s.Probes {
+		s.ETA = float64(s.ProbesTotal-s.Probes) / s.Rate
+	}
+	return s
+}
+
+// One line for the terminal
+func (s progressStatus) String() string {
+	line := fmt.Sprintf("%s %s: %d/%d hosts", s.Phase, s.Target, s.HostsDone, s.HostsTotal)
+	if s.ProbesTotal > 0 {
+		line += fmt.Sprintf(", %d/%d probes (%.1f%%), %.0f/s",
+			s.Probes, s.ProbesTotal, 100*float64(s.Probes)/float64(s.ProbesTotal), s.Rate)
+	}
+	line += fmt.Sprintf(", %d open", s.OpenPorts)
+	if s.ETA > 0 {
+		line += ", ETA " + (time.Duration(s.ETA) * time.Second).String()
+	}
+	return line
+}
+
+// Report progress until stop is called. mode is "line" (redrawn in place),
+// "json" (status events every interval), "off", or "auto" to pick line on a
+// terminal and json otherwise. On a terminal any keypress prints the status.
+func reportProgress(target, mode string, interval time.Duration) (stop func()) {
+	progress.mu.Lock()
+	progress.target = target
+	progress.mu.Unlock()
+	progress.open.Store(0)
+	progress.begin("starting", 0, 0)
+
+	if mode == "auto" {
+		mode = "json"
+		if isTerminal(os.Stderr) {
+			mode = "line"
+		}
+	}
+	if mode == "off" {
+		return func() {}
+	}
+
+	out := os.Stderr
+	tick := interval
+	if mode == "line" {
+		tick = 500 * time.Millisecond
+	}
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if mode == "line" {
+					fmt.Fprintf(out, "\r\x1b[K%s", progress.status("status"))
+				} else {
+					writeProgressEvent(out, progress.status("status"))
+				}
+			case <-quit:
+				if mode == "line" {
+					fmt.Fprint(out, "\r\x1b[K")
+				} else {
+					writeProgressEvent(out, progress.status("done"))
+				}
+				return
+			}
+		}
+	}()
+
+	// A background job touching the terminal would be stopped by SIGTTIN
+	if isTerminal(os.Stdin) && isForeground(os.Stdin) {
+		if restore, err := makeCbreak(os.Stdin); err == nil {
+			var once sync.Once
+			restore := func() { once.Do(restore) }
+			removeCleanup := atInterrupt(restore)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer removeCleanup()
+				defer restore()
+				watchKeys(quit, func() {
+					fmt.Fprintf(out, "\r\x1b[K%s\n", progress.status("status"))
+				})
+			}()
+		}
+	}
+
+	return func() {
+		close(quit)
+		wg.Wait()
+	}
+}
+
+func writeProgressEvent(w io.Writer, s progressStatus) {
+	data, err := json.Marshal(s)
+	if err == nil {
+		fmt.Fprintf(w, "%s\n", data)
+	}
+}
+
+// Call pressed for every key typed until quit is closed. Stdin must be in
+// cbreak mode so reads time out and quit is noticed.
+func watchKeys(quit chan struct{}, pressed func()) {
+	buf := make([]byte, 16)
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		n, _ := os.Stdin.Read(buf)
+		if n > 0 {
+			pressed()
+		}
+	}
+}
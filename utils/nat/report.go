@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Result of a whole scan run
+type ScanResult struct {
+	Target   string        `json:"target"`
+	Args     string        `json:"args,omitempty"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Hosts    []*HostResult `json:"hosts"`
+}
+
+// Everything learned about a single host
+type HostResult struct {
+	IP        string        `json:"ip"`
+	Hostnames []string      `json:"hostnames,omitempty"`
+	MAC       string        `json:"mac,omitempty"`
+	Vendor    string        `json:"vendor,omitempty"`
+	Services  []string      `json:"services,omitempty"`
+	State     string        `json:"state"`
+	OS        *OSGuess      `json:"os,omitempty"`
+	Ports     []*PortResult `json:"ports,omitempty"`
+}
+
+// State of a single port on a host
+type PortResult struct {
+	Port        int            `json:"port"`
+	Protocol    string         `json:"protocol"`
+	State       string         `json:"state"`
+	Service     string         `json:"service,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Banner      string         `json:"banner,omitempty"`
+	HTTP        *HTTPInfo      `json:"http,omitempty"`
+	SSH         *SSHInfo       `json:"ssh,omitempty"`
+	Checks      []*CheckResult `json:"checks,omitempty"`
+}
+
+// Find a host by IP, or nil if it is not part of the result
+func (r *ScanResult) host(ip string) *HostResult {
+	for _, h := range r.Hosts {
+		if h.IP == ip {
+			return h
+		}
+	}
+	return nil
+}
+
+// Sort hosts by address and ports by number so output is stable
+func (r *ScanResult) sort() {
+	sort.Slice(r.Hosts, func(i, j int) bool {
+		return compareIPs(r.Hosts[i].IP, r.Hosts[j].IP) < 0
+	})
+	for _, h := range r.Hosts {
+		sort.Slice(h.Ports, func(i, j int) bool {
+			if h.Ports[i].Port != h.Ports[j].Port {
+				return h.Ports[i].Port < h.Ports[j].Port
+			}
+			return h.Ports[i].Protocol < h.Ports[j].Protocol
+		})
+	}
+}
+
+// Compare two textual IPs numerically, falling back to string order
+func compareIPs(a, b string) int {
+	ipA, ipB := hostIP(a), hostIP(b)
+	if ipA == nil || ipB == nil {
+		return strings.Compare(a, b)
+	}
+	if v4 := ipA.To4(); v4 != nil {
+		ipA = v4
+	}
+	if v4 := ipB.To4(); v4 != nil {
+		ipB = v4
+	}
+	if len(ipA) != len(ipB) {
+		return len(ipA) - len(ipB)
+	}
+	return bytes.Compare(ipA, ipB)
+}
+
+// Count the open ports of a host
+func (h *HostResult) openPorts() int {
+	n := 0
+	for _, p := range h.Ports {
+		if p.State == "open" {
+			n++
+		}
+	}
+	return n
+}
+
+// Write the result in the requested format ("text", "json", "xml" or "grep")
+func writeReport(w io.Writer, result *ScanResult, format string) error {
+	result.sort()
+	switch format {
+	case "", "text":
+		return writeText(w, result)
+	case "json":
+		return writeJSON(w, result)
+	case "xml":
+		return writeXML(w, result)
+	case "grep":
+		return writeGrepable(w, result)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// Write the report to a file, or stdout when path is empty
+func saveReport(path string, result *ScanResult, format string) error {
+	if path == "" {
+		return writeReport(os.Stdout, result, format)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writeReport(file, result, format)
+}
+
+// Human-readable output, one block per host
+func writeText(w io.Writer, result *ScanResult) error {
+	for _, h := range result.Hosts {
+		writeHostText(w, h)
+	}
+	_, err := fmt.Fprintf(w, "Scan of %s done: %d host(s) up in %s\n",
+		result.Target, len(result.Hosts), result.Finished.Sub(result.Started).Round(time.Millisecond))
+	return err
+}
+
+// The text block of one host: a summary line, then its ports
+func writeHostText(w io.Writer, h *HostResult) {
+	line := "* " + h.IP
+	if len(h.Hostnames) > 0 {
+		line += " (" + h.Hostnames[0] + ")"
+	}
+	if len(h.Services) > 0 {
+		line += " (" + strings.Join(h.Services, ", ") + ")"
+	}
+	if h.MAC != "" {
+		vendor := h.Vendor
+		if vendor == "" {
+			vendor = "Unknown vendor"
+		}
+		line += fmt.Sprintf(" [%s %s]", h.MAC, vendor)
+	}
+	fmt.Fprintln(w, line)
+	if h.OS != nil {
+		fmt.Fprintf(w, "    OS: %s (%d%% confidence, initial TTL %d)\n", h.OS.Family, h.OS.Confidence, h.OS.InitialTTL)
+	}
+	for _, p := range h.Ports {
+		service := p.Description
+		if service == "" {
+			service = p.Service
+		}
+		if service == "" {
+			service = "Unknown"
+		}
+		fmt.Fprintf(w, "    %-11s %-8s %s\n", fmt.Sprintf("%d/%s", p.Port, p.Protocol), p.State, service)
+		if p.Banner != "" {
+			fmt.Fprintf(w, "        | %s\n", p.Banner)
+		}
+		if p.SSH != nil {
+			for _, key := range p.SSH.HostKeys {
+				fmt.Fprintf(w, "        | %s %s\n", key.Type, key.Fingerprint)
+			}
+			if len(p.SSH.Deprecated) > 0 {
+				fmt.Fprintf(w, "        | deprecated: %s\n", strings.Join(p.SSH.Deprecated, ", "))
+			}
+		}
+		for _, check := range p.Checks {
+			fmt.Fprintf(w, "        | [%s] %s\n", check.Check, check.Output)
+		}
+		if p.HTTP != nil {
+			fmt.Fprintf(w, "        | %s\n", p.HTTP.summary())
+			for _, url := range p.HTTP.Redirects {
+				fmt.Fprintf(w, "        |   -> %s\n", url)
+			}
+			for _, path := range p.HTTP.interestingPaths() {
+				fmt.Fprintf(w, "        |   %s %d\n", path.Path, path.Status)
+			}
+		}
+	}
+}
+
+func writeJSON(w io.Writer, result *ScanResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// One line per host, compatible with the nmap -oG layout
+func writeGrepable(w io.Writer, result *ScanResult) error {
+	fmt.Fprintf(w, "# netshell scan initiated %s as: %s\n", result.Started.Format(time.ANSIC), result.Args)
+	for _, h := range result.Hosts {
+		name := ""
+		if len(h.Hostnames) > 0 {
+			name = h.Hostnames[0]
+		}
+		fmt.Fprintf(w, "Host: %s (%s)\tStatus: Up\n", h.IP, name)
+		if len(h.Ports) == 0 {
+			continue
+		}
+		entries := make([]string, 0, len(h.Ports))
+		for _, p := range h.Ports {
+			entries = append(entries, fmt.Sprintf("%d/%s/%s//%s//%s/",
+				p.Port, p.State, p.Protocol, p.Service, grepEscape(p.Description)))
+		}
+		line := fmt.Sprintf("Host: %s (%s)\tPorts: %s", h.IP, name, strings.Join(entries, ", "))
+		if h.OS != nil {
+			line += "\tOS: " + grepEscape(h.OS.Family)
+		}
+		fmt.Fprintln(w, line)
+	}
+	_, err := fmt.Fprintf(w, "# netshell done at %s -- %d IP address(es) (%d host(s) up) scanned in %.2f seconds\n",
+		result.Finished.Format(time.ANSIC), len(result.Hosts), len(result.Hosts), result.Finished.Sub(result.Started).Seconds())
+	return err
+}
+
+// Grepable fields are separated by '/' and ',' so strip them from free text
+func grepEscape(s string) string {
+	return strings.NewReplacer("/", "|", ",", "").Replace(s)
+}
+
+// nmap-compatible XML document (subset of the nmap.dtd)
+type nmapRun struct {
+	XMLName          xml.Name   `xml:"nmaprun"`
+	Scanner          string     `xml:"scanner,attr"`
+	Args             string     `xml:"args,attr"`
+	Start            int64      `xml:"start,attr"`
+	StartStr         string     `xml:"startstr,attr"`
+	Version          string     `xml:"version,attr"`
+	XMLOutputVersion string     `xml:"xmloutputversion,attr"`
+	Hosts            []nmapHost `xml:"host"`
+	RunStats         nmapStats  `xml:"runstats"`
+}
+
+type nmapHost struct {
+	Status    nmapStatus     `xml:"status"`
+	Addresses []nmapAddress  `xml:"address"`
+	Hostnames []nmapHostname `xml:"hostnames>hostname"`
+	Ports     []nmapPort     `xml:"ports>port"`
+	OS        *nmapOS        `xml:"os,omitempty"`
+}
+
+type nmapOS struct {
+	Matches []nmapOSMatch `xml:"osmatch"`
+}
+
+type nmapOSMatch struct {
+	Name     string `xml:"name,attr"`
+	Accuracy int    `xml:"accuracy,attr"`
+	Line     int    `xml:"line,attr"`
+}
+
+type nmapStatus struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+	Vendor   string `xml:"vendor,attr,omitempty"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapState    `xml:"state"`
+	Service  nmapService  `xml:"service"`
+	Scripts  []nmapScript `xml:"script"`
+}
+
+type nmapScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+type nmapState struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type nmapService struct {
+	Name      string `xml:"name,attr"`
+	ExtraInfo string `xml:"extrainfo,attr,omitempty"`
+	Method    string `xml:"method,attr"`
+	Conf      int    `xml:"conf,attr"`
+}
+
+type nmapStats struct {
+	Finished nmapFinished `xml:"finished"`
+	Hosts    nmapHostsRun `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time    int64   `xml:"time,attr"`
+	TimeStr string  `xml:"timestr,attr"`
+	Elapsed float64 `xml:"elapsed,attr"`
+	Exit    string  `xml:"exit,attr"`
+}
+
+type nmapHostsRun struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+func writeXML(w io.Writer, result *ScanResult) error {
+	run := nmapRun{
+		Scanner:          "netshell",
+		Args:             result.Args,
+		Start:            result.Started.Unix(),
+		StartStr:         result.Started.Format(time.ANSIC),
+		Version:          "1.0",
+		XMLOutputVersion: "1.05",
+	}
+	for _, h := range result.Hosts {
+		host := nmapHost{Status: nmapStatus{State: "up", Reason: "lookup"}}
+		addrType := "ipv4"
+		if ip := hostIP(h.IP); ip != nil && ip.To4() == nil {
+			addrType = "ipv6"
+		}
+		host.Addresses = append(host.Addresses, nmapAddress{Addr: h.IP, AddrType: addrType})
+		if h.MAC != "" {
+			host.Addresses = append(host.Addresses, nmapAddress{Addr: strings.ToUpper(h.MAC), AddrType: "mac", Vendor: h.Vendor})
+		}
+		for _, name := range h.Hostnames {
+			host.Hostnames = append(host.Hostnames, nmapHostname{Name: name, Type: "PTR"})
+		}
+		for _, p := range h.Ports {
+			port := nmapPort{
+				Protocol: p.Protocol,
+				PortID:   p.Port,
+				State:    nmapState{State: p.State, Reason: "syn-ack"},
+				Service:  nmapService{Name: p.Service, ExtraInfo: p.Description, Method: "table", Conf: 3},
+			}
+			if p.Banner != "" {
+				port.Scripts = append(port.Scripts, nmapScript{ID: "banner", Output: p.Banner})
+			}
+			if p.SSH != nil {
+				var keys []string
+				for _, key := range p.SSH.HostKeys {
+					keys = append(keys, key.Type+" "+key.Fingerprint)
+				}
+				port.Scripts = append(port.Scripts,
+					nmapScript{ID: "ssh-hostkey", Output: strings.Join(keys, ", ")},
+					nmapScript{ID: "ssh2-enum-algos", Output: p.SSH.algorithmSummary()})
+			}
+			if p.HTTP != nil {
+				port.Scripts = append(port.Scripts, nmapScript{ID: "http-title", Output: p.HTTP.summary()})
+				var paths []string
+				for _, path := range p.HTTP.interestingPaths() {
+					paths = append(paths, fmt.Sprintf("%s %d", path.Path, path.Status))
+				}
+				if len(paths) > 0 {
+					port.Scripts = append(port.Scripts, nmapScript{ID: "http-enum", Output: strings.Join(paths, ", ")})
+				}
+			}
+			for _, check := range p.Checks {
+				port.Scripts = append(port.Scripts, nmapScript{ID: check.Check, Output: check.Output})
+			}
+			host.Ports = append(host.Ports, port)
+		}
+		if h.OS != nil {
+			host.OS = &nmapOS{Matches: []nmapOSMatch{{Name: h.OS.Family, Accuracy: h.OS.Confidence}}}
+		}
+		run.Hosts = append(run.Hosts, host)
+	}
+	run.RunStats = nmapStats{
+		Finished: nmapFinished{
+			Time:    result.Finished.Unix(),
+			TimeStr: result.Finished.Format(time.ANSIC),
+			Elapsed: result.Finished.Sub(result.Started).Seconds(),
+			Exit:    "success",
+		},
+		Hosts: nmapHostsRun{Up: len(run.Hosts), Total: len(run.Hosts)},
+	}
+
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, "<!DOCTYPE nmaprun>\n")
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(run); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed services.txt
+var bundledServices string
+
+// A port/protocol entry of the service database
+type serviceEntry struct {
+	Name        string
+	Port        int
+	Protocol    string
+	Frequency   float64
+	Description string
+}
+
+// Service database keyed by "port/protocol"
+var services = map[string]serviceEntry{}
+
+// Load the bundled database, then the user's file on top of it
+func loadServices(overridePath string) error {
+	if err := parseServices(strings.NewReader(bundledServices)); err != nil {
+		return err
+	}
+	if overridePath == "" {
+		return nil
+	}
+	file, err := os.Open(overridePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return parseServices(file)
+}
+
+// Parse nmap-services or /etc/services style lines
+func parseServices(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		description := ""
+		if i := strings.Index(line, "#"); i >= 0 {
+			description = strings.TrimSpace(line[i+1:])
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		portStr, protocol, ok := strings.Cut(fields[1], "/")
+		port, err := strconv.Atoi(portStr)
+		if !ok || err != nil {
+			continue
+		}
+		entry := serviceEntry{Name: fields[0], Port: port, Protocol: strings.ToLower(protocol), Description: description}
+		key := serviceKey(port, entry.Protocol)
+		// The third column is the frequency in nmap-services, an alias in
+		// /etc/services. Without a frequency the entry only renames the
+		// port, keeping how common it is for --top-ports.
+		hasFrequency := false
+		if len(fields) > 2 {
+			frequency, err := strconv.ParseFloat(fields[2], 64)
+			entry.Frequency, hasFrequency = frequency, err == nil
+		}
+		if previous, known := services[key]; known && !hasFrequency {
+			entry.Frequency = previous.Frequency
+			if entry.Description == "" {
+				entry.Description = previous.Description
+			}
+		}
+		services[key] = entry
+	}
+	return scanner.Err()
+}
+
+func serviceKey(port int, protocol string) string {
+	return strconv.Itoa(port) + "/" + protocol
+}
+
+// Look up a port; unknown ports get an empty entry
+func lookupService(port int, protocol string) serviceEntry {
+	return services[serviceKey(port, protocol)]
+}
+
+// The n most frequently open ports of a protocol, most frequent first
+func topPorts(n int, protocol string) []int {
+	var entries []serviceEntry
+	for _, e := range services {
+		if e.Protocol == protocol {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Frequency != entries[j].Frequency {
+			return entries[i].Frequency > entries[j].Frequency
+		}
+		return entries[i].Port < entries[j].Port
+	})
+	if n > len(entries) {
+		fmt.Fprintf(os.Stderr, "The service database has only %d %s ports, using all of them instead of the top %d\n", len(entries), protocol, n)
+		n = len(entries)
+	}
+	ports := make([]int, n)
+	for i, e := range entries[:n] {
+		ports[i] = e.Port
+	}
+	return ports
+}
+
+// Compact form of a port list, e.g. "1-1024,8080"
+func formatPortRanges(ports []int) string {
+	sorted := append([]int(nil), ports...)
+	sort.Ints(sorted)
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse "22,80,8000-8100" into a port list
+func parsePortRanges(spec string) ([]int, error) {
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		for port := start; port <= end; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// Ports 1..n
+func portRange(n int) ([]int, error) {
+	if n < 1 || n > 65535 {
+		return nil, fmt.Errorf("--portrange must be between 1 and 65535, got %d", n)
+	}
+	ports := make([]int, n)
+	for i := range ports {
+		ports[i] = i + 1
+	}
+	return ports, nil
+}
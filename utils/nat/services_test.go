@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPortRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr bool
+	}{
+		{"one port", 1, false},
+		{"default", 1024, false},
+		{"every port", 65535, false},
+		{"zero", 0, true},
+		{"negative", -1, true},
+		{"very negative", -65536, true},
+		{"past the last port", 65536, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, err := portRange(tt.n)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got %d ports", len(ports))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("portRange(%d): %v", tt.n, err)
+			}
+			if len(ports) != tt.n || ports[0] != 1 || ports[len(ports)-1] != tt.n {
+				t.Fatalf("want ports 1..%d, got %d ports %v..%v", tt.n, len(ports), ports[0], ports[len(ports)-1])
+			}
+		})
+	}
+}
+
+func TestParseServicesOverride(t *testing.T) {
+	tests := []struct {
+		name      string
+		override  string
+		service   string
+		frequency float64
+		desc      string
+	}{
+		{"alias", "www 80/tcp http # WorldWideWeb HTTP", "www", 0.48, "WorldWideWeb HTTP"},
+		{"several aliases", "www 80/tcp http web", "www", 0.48, "World Wide Web HTTP"},
+		{"no third column", "web 80/tcp", "web", 0.48, "World Wide Web HTTP"},
+		{"new frequency", "http 80/tcp 0.9", "http", 0.9, ""},
+		{"zero frequency", "http 80/tcp 0", "http", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services = map[string]serviceEntry{}
+			bundled := "http\t80/tcp\t0.484143\t# World Wide Web HTTP\n"
+			if err := parseServices(strings.NewReader(bundled + tt.override + "\n")); err != nil {
+				t.Fatalf("parseServices: %v", err)
+			}
+			e := lookupService(80, "tcp")
+			if e.Name != tt.service || e.Description != tt.desc || math.Abs(e.Frequency-tt.frequency) > 0.01 {
+				t.Fatalf("got %+v, want %s with frequency %v and description %q", e, tt.service, tt.frequency, tt.desc)
+			}
+		})
+	}
+}
+
+func TestTopPortsKeepsOrderAfterAliases(t *testing.T) {
+	services = map[string]serviceEntry{}
+	bundled := "http\t80/tcp\t0.48\nssh\t22/tcp\t0.18\ntelnet\t23/tcp\t0.22\n"
+	etc := "www 80/tcp http\nssh 22/tcp\n"
+	if err := parseServices(strings.NewReader(bundled + etc)); err != nil {
+		t.Fatalf("parseServices: %v", err)
+	}
+	got := topPorts(2, "tcp")
+	if len(got) != 2 || got[0] != 80 || got[1] != 23 {
+		t.Fatalf("topPorts(2) = %v, want [80 23]", got)
+	}
+	if got := topPorts(5, "tcp"); len(got) != 3 {
+		t.Fatalf("topPorts(5) = %v, want all 3 ports", got)
+	}
+}
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The interactive console started by "netshell shell"
+func shellCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "shell",
+		Usage: "Interactive console that keeps targets and scan results between commands",
+		Description: "Global scan flags (--portrange, --top-ports, --http, --ssh, --checks, ...) set the\n" +
+			"initial options and --on the initial target. Type \"help\" inside the shell.",
+		Action: func(c *cli.Context) error {
+			if err := loadOUI(c.String("oui")); err != nil {
+				return err
+			}
+			if err := loadServices(c.String("services")); err != nil {
+				return err
+			}
+			if err := configureProxies(c.StringSlice("proxy"), c.Duration("proxy-timeout"), c.Int("proxy-concurrency")); err != nil {
+				return err
+			}
+			opts, err := scanOptionsFrom(c)
+			if err != nil {
+				return err
+			}
+			s := newShell(opts)
+			if target := c.String("on"); target != "" {
+				s.targets = append(s.targets, target)
+			}
+			return s.run()
+		},
+	}
+}
+
+// State kept between the commands of the interactive shell
+type shell struct {
+	targets  []string
+	opts     scanOptions
+	results  []*ScanResult
+	commands []shellCmd
+	editor   *lineEditor
+}
+
+// A shell command. complete returns candidates for the last (partial) argument.
+type shellCmd struct {
+	name     string
+	args     string
+	usage    string
+	run      func(args []string) error
+	complete func(args []string) []string
+}
+
+// Options that "set" can change
+var shellOptionNames = []string{"ports", "localnames", "osdetect", "http", "ssh", "checks", "ipv6-max-prefix"}
+
+var reportFormats = []string{"text", "json", "xml", "grep"}
+
+var errExit = fmt.Errorf("exit")
+
+func newShell(opts scanOptions) *shell {
+	s := &shell{opts: opts}
+	s.commands = []shellCmd{
+		{"help", "[command]", "Show the commands, or the usage of one", s.help, s.completeCommands},
+		{"targets", "", "List the targets", s.listTargets, nil},
+		{"add", "<cidr|ip>...", "Add scan targets", s.addTargets, s.completeNetworks},
+		{"remove", "<cidr|ip>...", "Remove scan targets", s.removeTargets, s.completeTargets},
+		{"set", "[option [value]]", "Show or change a scan option", s.set, s.completeSet},
+		{"discover", "[target...]", "Find the hosts that are up", s.scanCmd("discover", false), s.completeTargets},
+		{"scan", "[target...]", "Find hosts and scan their ports", s.scanCmd("scan", true), s.completeTargets},
+		{"results", "", "List the results of previous scans", s.listResults, nil},
+		{"hosts", "[#n]", "List the hosts of a result (the latest by default)", s.hosts, nil},
+		{"filter", "<service|port> [#n]", "List the hosts with that service or port open", s.filter, s.completeServices},
+		{"show", "<ip> [#n]", "Show everything found about a host", s.show, s.completeHosts},
+		{"diff", "[#old] [#new]", "Compare two results (the latest two of a target by default)", s.diff, nil},
+		{"export", "<format> <file|-> [#n]", "Write a result as text, json, xml or grep", s.export, s.completeExport},
+		{"load", "<file>", "Load a JSON report written earlier", s.load, s.completeFile},
+		{"history", "", "Show the command history", s.history, nil},
+		{"exit", "", "Leave the shell", func([]string) error { return errExit }, nil},
+	}
+	return s
+}
+
+func (s *shell) run() error {
+	histPath := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		histPath = filepath.Join(home, ".netshell_history")
+	}
+	s.editor = newLineEditor(histPath, s.completeLine)
+
+	fmt.Println(`netshell interactive mode, type "help" for commands`)
+	for {
+		line, err := s.editor.readLine("netshell> ")
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cmd := s.command(fields[0])
+		if fields[0] == "quit" {
+			cmd = s.command("exit")
+		}
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command %q, type \"help\" for a list\n", fields[0])
+			continue
+		}
+		if err := cmd.run(fields[1:]); err == errExit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}
+}
+
+func (s *shell) command(name string) *shellCmd {
+	for i := range s.commands {
+		if s.commands[i].name == name {
+			return &s.commands[i]
+		}
+	}
+	return nil
+}
+
+func (s *shell) help(args []string) error {
+	if len(args) > 0 {
+		cmd := s.command(args[0])
+		if cmd == nil {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		fmt.Printf("%s %s\n    %s\n", cmd.name, cmd.args, cmd.usage)
+		if cmd.name == "set" {
+			fmt.Println("    Options: " + strings.Join(shellOptionNames, ", "))
+		}
+		return nil
+	}
+	for _, cmd := range s.commands {
+		fmt.Printf("  %-32s %s\n", cmd.name+" "+cmd.args, cmd.usage)
+	}
+	fmt.Println("Tab completes commands and arguments, Up/Down browse the history.")
+	return nil
+}
+
+func (s *shell) listTargets([]string) error {
+	if len(s.targets) == 0 {
+		fmt.Println("No targets, add some with \"add <cidr>\"")
+	}
+	for _, t := range s.targets {
+		fmt.Println("  " + t)
+	}
+	return nil
+}
+
+// Accept a CIDR or a single address, which becomes a /32 or /128
+func normalizeTarget(arg string) (string, error) {
+	if _, ipnet, err := net.ParseCIDR(arg); err == nil {
+		return ipnet.String(), nil
+	}
+	ip := net.ParseIP(arg)
+	if ip == nil {
+		return "", fmt.Errorf("%q is neither a CIDR nor an IP address", arg)
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
+
+func (s *shell) addTargets(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: add <cidr|ip>...")
+	}
+	for _, arg := range args {
+		target, err := normalizeTarget(arg)
+		if err != nil {
+			return err
+		}
+		if !contains(s.targets, target) {
+			s.targets = append(s.targets, target)
+		}
+	}
+	return s.listTargets(nil)
+}
+
+func (s *shell) removeTargets(args []string) error {
+	for _, arg := range args {
+		target, err := normalizeTarget(arg)
+		if err != nil {
+			return err
+		}
+		found := false
+		for i, t := range s.targets {
+			if t == target || t == arg {
+				s.targets = append(s.targets[:i], s.targets[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not a target", arg)
+		}
+	}
+	return s.listTargets(nil)
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func parseOnOff(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1":
+		return true, nil
+	case "off", "false", "no", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", value)
+}
+
+func (s *shell) optionValue(name string) string {
+	switch name {
+	case "ports":
+		return formatPortRanges(s.opts.ports)
+	case "localnames":
+		return onOff(s.opts.localNames)
+	case "osdetect":
+		return onOff(s.opts.osDetect)
+	case "http":
+		return onOff(s.opts.http)
+	case "ssh":
+		return onOff(s.opts.ssh)
+	case "checks":
+		var names []string
+		for _, check := range s.opts.checks {
+			names = append(names, check.Name())
+		}
+		if len(names) == 0 {
+			return "none"
+		}
+		return strings.Join(names, ",")
+	case "ipv6-max-prefix":
+		return strconv.Itoa(s.opts.ipv6MaxPrefix)
+	}
+	return ""
+}
+
+func (s *shell) set(args []string) error {
+	if len(args) == 0 {
+		for _, name := range shellOptionNames {
+			fmt.Printf("  %-16s %s\n", name, s.optionValue(name))
+		}
+		return nil
+	}
+	if len(args) == 1 {
+		if !contains(shellOptionNames, args[0]) {
+			return fmt.Errorf("unknown option %q (options: %s)", args[0], strings.Join(shellOptionNames, ", "))
+		}
+		fmt.Printf("  %-16s %s\n", args[0], s.optionValue(args[0]))
+		return nil
+	}
+
+	name, value := args[0], strings.Join(args[1:], "")
+	var err error
+	switch name {
+	case "ports":
+		// "top100" for the most common ports, otherwise a list like "22,80,8000-8100"
+		if n, ok := strings.CutPrefix(value, "top"); ok {
+			count, convErr := strconv.Atoi(n)
+			if convErr != nil || count < 1 {
+				return fmt.Errorf("expected top<count>, e.g. top100")
+			}
+			s.opts.ports = topPorts(count, "tcp")
+			break
+		}
+		ports, parseErr := parsePortRanges(value)
+		if parseErr != nil {
+			return parseErr
+		}
+		if len(ports) == 0 {
+			return fmt.Errorf("no ports in %q", value)
+		}
+		s.opts.ports = ports
+	case "localnames":
+		s.opts.localNames, err = parseOnOff(value)
+	case "osdetect":
+		s.opts.osDetect, err = parseOnOff(value)
+	case "http":
+		s.opts.http, err = parseOnOff(value)
+	case "ssh":
+		s.opts.ssh, err = parseOnOff(value)
+	case "checks":
+		if value == "none" {
+			s.opts.checks = nil
+			break
+		}
+		var checks []ScanCheck
+		if checks, err = selectChecks(value); err == nil {
+			s.opts.checks = checks
+		}
+	case "ipv6-max-prefix":
+		var prefix int
+		if prefix, err = strconv.Atoi(value); err == nil {
+			s.opts.ipv6MaxPrefix = prefix
+		}
+	default:
+		return fmt.Errorf("unknown option %q (options: %s)", name, strings.Join(shellOptionNames, ", "))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  %-16s %s\n", name, s.optionValue(name))
+	return nil
+}
+
+// "discover" or "scan" over the given targets, or all of them
+func (s *shell) scanCmd(name string, ports bool) func(args []string) error {
+	return func(args []string) error {
+		targets := s.targets
+		if len(args) > 0 {
+			targets = nil
+			for _, arg := range args {
+				target, err := normalizeTarget(arg)
+				if err != nil {
+					return err
+				}
+				targets = append(targets, target)
+			}
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("no targets, add some with \"add <cidr>\"")
+		}
+
+		for _, target := range targets {
+			opts := s.opts
+			opts.target = target
+			opts.args = "netshell shell: " + strings.Join(append([]string{name}, args...), " ")
+			opts.discover = true
+			opts.scanPorts = ports
+			opts.osDetect = ports && opts.osDetect
+			if !ports {
+				opts.http, opts.ssh, opts.checks = false, false, nil
+			}
+			opts.checkpoint = ""
+
+			result := runScan(opts, nil)
+			result.sort()
+			s.results = append(s.results, result)
+			fmt.Printf("#%d %s: %d host(s) up, %d open port(s) in %s\n", len(s.results), target,
+				len(result.Hosts), openPortCount(result), result.Finished.Sub(result.Started).Round(time.Millisecond))
+		}
+		return nil
+	}
+}
+
+func openPortCount(result *ScanResult) int {
+	n := 0
+	for _, h := range result.Hosts {
+		n += h.openPorts()
+	}
+	return n
+}
+
+func (s *shell) listResults([]string) error {
+	if len(s.results) == 0 {
+		fmt.Println("No results yet, run \"scan\" or \"discover\"")
+	}
+	for i, r := range s.results {
+		fmt.Printf("  #%-3d %s  %-20s %3d host(s) %4d open port(s)\n", i+1,
+			r.Started.Format("2006-01-02 15:04:05"), r.Target, len(r.Hosts), openPortCount(r))
+	}
+	return nil
+}
+
+// Split a trailing "#n" result reference off args; without one the latest result is used
+func (s *shell) result(args []string) (*ScanResult, []string, error) {
+	if len(s.results) == 0 {
+		return nil, nil, fmt.Errorf("no results yet, run \"scan\" or \"discover\"")
+	}
+	if len(args) > 0 && strings.HasPrefix(args[len(args)-1], "#") {
+		ref := args[len(args)-1]
+		r, err := s.resultRef(ref)
+		return r, args[:len(args)-1], err
+	}
+	return s.results[len(s.results)-1], args, nil
+}
+
+func (s *shell) resultRef(ref string) (*ScanResult, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(ref, "#"))
+	if err != nil || n < 1 || n > len(s.results) {
+		return nil, fmt.Errorf("no result %s (see \"results\")", ref)
+	}
+	return s.results[n-1], nil
+}
+
+func hostSummary(h *HostResult) string {
+	name := ""
+	if len(h.Hostnames) > 0 {
+		name = h.Hostnames[0]
+	}
+	var ports []string
+	for _, p := range h.Ports {
+		if p.State == "open" {
+			ports = append(ports, strconv.Itoa(p.Port))
+		}
+	}
+	return fmt.Sprintf("  %-40s %-30s %s", h.IP, name, strings.Join(ports, ","))
+}
+
+func (s *shell) hosts(args []string) error {
+	result, _, err := s.result(args)
+	if err != nil {
+		return err
+	}
+	for _, h := range result.Hosts {
+		fmt.Println(hostSummary(h))
+	}
+	fmt.Printf("%d host(s) in %s\n", len(result.Hosts), result.Target)
+	return nil
+}
+
+func (s *shell) filter(args []string) error {
+	result, args, err := s.result(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: filter <service|port> [#n]")
+	}
+	want := args[0]
+	port, byPort := strconv.Atoi(want)
+	matched := 0
+	for _, h := range result.Hosts {
+		for _, p := range h.Ports {
+			if p.State != "open" {
+				continue
+			}
+			// Ports identified by the HTTP and SSH probes match too, whatever their number
+			identified := (p.HTTP != nil && strings.EqualFold(want, "http")) || (p.SSH != nil && strings.EqualFold(want, "ssh"))
+			if (byPort == nil && p.Port == port) || strings.EqualFold(p.Service, want) || identified {
+				fmt.Printf("  %-40s %-11s %s\n", h.IP, fmt.Sprintf("%d/%s", p.Port, p.Protocol), p.Service)
+				matched++
+			}
+		}
+	}
+	fmt.Printf("%d match(es)\n", matched)
+	return nil
+}
+
+func (s *shell) show(args []string) error {
+	result, args, err := s.result(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: show <ip> [#n]")
+	}
+	h := result.host(args[0])
+	if h == nil {
+		return fmt.Errorf("%s is not in result for %s", args[0], result.Target)
+	}
+	writeHostText(os.Stdout, h)
+	return nil
+}
+
+func (s *shell) diff(args []string) error {
+	if len(s.results) == 0 {
+		return fmt.Errorf("no results yet, run \"scan\" or \"discover\"")
+	}
+	var old, cur *ScanResult
+	var err error
+	switch len(args) {
+	case 0:
+		cur = s.results[len(s.results)-1]
+		for i := len(s.results) - 2; i >= 0; i-- {
+			if s.results[i].Target == cur.Target {
+				old = s.results[i]
+				break
+			}
+		}
+		if old == nil {
+			return fmt.Errorf("%s was only scanned once", cur.Target)
+		}
+	case 1:
+		if old, err = s.resultRef(args[0]); err != nil {
+			return err
+		}
+		cur = s.results[len(s.results)-1]
+	case 2:
+		if old, err = s.resultRef(args[0]); err != nil {
+			return err
+		}
+		if cur, err = s.resultRef(args[1]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("usage: diff [#old] [#new]")
+	}
+	return writeDiff(os.Stdout, diffResults(old, cur))
+}
+
+func (s *shell) export(args []string) error {
+	result, args, err := s.result(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: export <format> <file|-> [#n]")
+	}
+	path := args[1]
+	if path == "-" {
+		path = ""
+	}
+	if err := saveReport(path, result, args[0]); err != nil {
+		return err
+	}
+	if path != "" {
+		fmt.Printf("Wrote %s\n", path)
+	}
+	return nil
+}
+
+func (s *shell) load(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: load <file>")
+	}
+	result, err := loadReport(args[0])
+	if err != nil {
+		return err
+	}
+	result.sort()
+	s.results = append(s.results, result)
+	fmt.Printf("#%d %s: %d host(s), %d open port(s)\n", len(s.results), result.Target, len(result.Hosts), openPortCount(result))
+	return nil
+}
+
+func (s *shell) history([]string) error {
+	for i, line := range s.editor.history {
+		fmt.Printf("%5d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+// Completion of the whole line: command names first, then the command's arguments
+func (s *shell) completeLine(line string) []string {
+	fields := strings.Fields(line)
+	if strings.HasSuffix(line, " ") || len(fields) == 0 {
+		fields = append(fields, "")
+	}
+	if len(fields) == 1 {
+		return s.completeCommands(fields)
+	}
+	cmd := s.command(fields[0])
+	if cmd == nil || cmd.complete == nil {
+		return nil
+	}
+	return cmd.complete(fields[1:])
+}
+
+func last(args []string) string {
+	return args[len(args)-1]
+}
+
+func (s *shell) completeCommands(args []string) []string {
+	if len(args) > 1 {
+		return nil
+	}
+	var names []string
+	for _, cmd := range s.commands {
+		names = append(names, cmd.name)
+	}
+	return completeFrom(last(args), names)
+}
+
+func (s *shell) completeTargets(args []string) []string {
+	return completeFrom(last(args), s.targets)
+}
+
+// Networks of the local interfaces, handy targets for "add"
+func (s *shell) completeNetworks(args []string) []string {
+	var networks []string
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
+			_, network, _ := net.ParseCIDR(ipnet.String())
+			networks = append(networks, network.String())
+		}
+	}
+	return completeFrom(last(args), networks)
+}
+
+func (s *shell) completeSet(args []string) []string {
+	switch len(args) {
+	case 1:
+		return completeFrom(args[0], shellOptionNames)
+	case 2:
+		switch args[0] {
+		case "localnames", "osdetect", "http", "ssh":
+			return completeFrom(args[1], []string{"on", "off"})
+		case "checks":
+			return completeFrom(args[1], append([]string{"all", "none"}, checkNames()...))
+		case "ports":
+			return completeFrom(args[1], []string{"top100", "top1000", "1-1024", "1-65535"})
+		}
+	}
+	return nil
+}
+
+func (s *shell) latestHosts() []*HostResult {
+	if len(s.results) == 0 {
+		return nil
+	}
+	return s.results[len(s.results)-1].Hosts
+}
+
+func (s *shell) completeServices(args []string) []string {
+	if len(args) > 1 {
+		return nil
+	}
+	var names []string
+	for _, h := range s.latestHosts() {
+		for _, p := range h.Ports {
+			if p.Service != "" {
+				names = append(names, p.Service)
+			}
+		}
+	}
+	sort.Strings(names)
+	return completeFrom(args[0], names)
+}
+
+func (s *shell) completeHosts(args []string) []string {
+	if len(args) > 1 {
+		return nil
+	}
+	var ips []string
+	for _, h := range s.latestHosts() {
+		ips = append(ips, h.IP)
+	}
+	return completeFrom(args[0], ips)
+}
+
+func (s *shell) completeExport(args []string) []string {
+	switch len(args) {
+	case 1:
+		return completeFrom(args[0], reportFormats)
+	case 2:
+		return completeFiles(args[1])
+	}
+	return nil
+}
+
+func (s *shell) completeFile(args []string) []string {
+	if len(args) > 1 {
+		return nil
+	}
+	return completeFiles(args[0])
+}
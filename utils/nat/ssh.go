@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSH server configuration learned from the start of a key exchange
+type SSHInfo struct {
+	Version     string       `json:"version"`
+	Kex         []string     `json:"kex"`
+	HostKeyAlgs []string     `json:"host_key_algorithms"`
+	Ciphers     []string     `json:"ciphers"`
+	MACs        []string     `json:"macs"`
+	Compression []string     `json:"compression,omitempty"`
+	HostKeys    []SSHHostKey `json:"host_keys,omitempty"`
+	Deprecated  []string     `json:"deprecated,omitempty"`
+}
+
+// Host key type and its OpenSSH style fingerprint
+type SSHHostKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Algorithms considered weak or deprecated by current OpenSSH
+var deprecatedSSHAlgorithms = map[string]bool{
+	"diffie-hellman-group1-sha1":         true,
+	"diffie-hellman-group14-sha1":        true,
+	"diffie-hellman-group-exchange-sha1": true,
+	"ssh-dss":                            true,
+	"ssh-rsa":                            true,
+	"3des-cbc":                           true,
+	"aes128-cbc":                         true,
+	"aes192-cbc":                         true,
+	"aes256-cbc":                         true,
+	"blowfish-cbc":                       true,
+	"cast128-cbc":                        true,
+	"arcfour":                            true,
+	"arcfour128":                         true,
+	"arcfour256":                         true,
+	"rijndael-cbc@lysator.liu.se":        true,
+	"hmac-md5":                           true,
+	"hmac-md5-96":                        true,
+	"hmac-md5-etm@openssh.com":           true,
+	"hmac-md5-96-etm@openssh.com":        true,
+	"hmac-sha1":                          true,
+	"hmac-sha1-96":                       true,
+	"hmac-sha1-etm@openssh.com":          true,
+	"hmac-sha1-96-etm@openssh.com":       true,
+	"hmac-ripemd160":                     true,
+	"hmac-ripemd160@openssh.com":         true,
+	"umac-64@openssh.com":                true,
+	"umac-64-etm@openssh.com":            true,
+	"ssh-rsa-cert-v01@openssh.com":       true,
+	"ssh-dss-cert-v01@openssh.com":       true,
+}
+
+const (
+	sshMsgKexInit      = 20
+	sshMsgKexECDHInit  = 30
+	sshMsgKexECDHReply = 31
+	sshTimeout         = 5 * time.Second
+)
+
+// Key exchanges we can run to get the server to send its host key
+var sshKexSupported = []string{"curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256"}
+
+// Inventory every open port that looks like SSH: port 22, a service named
+// ssh or an SSH banner. Servers that wait for the client's version string
+// send no banner in time for the grab, so the handshake reads it itself.
+func inventorySSHPorts(hosts []*HostResult, results sync.Locker) {
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		for _, p := range h.Ports {
+			if p.State != "open" || !looksLikeSSH(p) {
+				continue
+			}
+			wg.Add(1)
+			go func(ip string, p *PortResult) {
+				defer wg.Done()
+				info, err := inventorySSH(net.JoinHostPort(ip, strconv.Itoa(p.Port)))
+				if err == nil {
+					results.Lock()
+					p.SSH = info
+					results.Unlock()
+				}
+			}(h.IP, p)
+		}
+	}
+	wg.Wait()
+}
+
+func looksLikeSSH(p *PortResult) bool {
+	return p.Port == 22 || strings.EqualFold(p.Service, "ssh") || strings.HasPrefix(p.Banner, "SSH-")
+}
+
+// Read the server's algorithm lists, then run one key exchange per host key type
+func inventorySSH(addr string) (*SSHInfo, error) {
+	info, kexinit, err := sshAlgorithms(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, alg := range info.HostKeyAlgs {
+		// Certificates wrap keys we already see; rsa-sha2-* variants are deduplicated by fingerprint
+		if strings.Contains(alg, "-cert-") {
+			continue
+		}
+		key, err := sshHostKey(addr, kexinit, alg)
+		if err != nil {
+			continue
+		}
+		if !hasHostKey(info.HostKeys, key.Fingerprint) {
+			info.HostKeys = append(info.HostKeys, key)
+		}
+	}
+
+	for _, list := range [][]string{info.Kex, info.HostKeyAlgs, info.Ciphers, info.MACs} {
+		for _, alg := range list {
+			if deprecatedSSHAlgorithms[alg] && !hasString(info.Deprecated, alg) {
+				info.Deprecated = append(info.Deprecated, alg)
+			}
+		}
+	}
+	if strings.HasPrefix(info.Version, "SSH-1.") {
+		info.Deprecated = append(info.Deprecated, "protocol version 1")
+	}
+	return info, nil
+}
+
+// Algorithm lists in one line, deprecated ones included
+func (s *SSHInfo) algorithmSummary() string {
+	summary := fmt.Sprintf("kex: %s; hostkey: %s; ciphers: %s; macs: %s",
+		strings.Join(s.Kex, ","), strings.Join(s.HostKeyAlgs, ","), strings.Join(s.Ciphers, ","), strings.Join(s.MACs, ","))
+	if len(s.Deprecated) > 0 {
+		summary += "; deprecated: " + strings.Join(s.Deprecated, ",")
+	}
+	return summary
+}
+
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasHostKey(keys []SSHHostKey, fingerprint string) bool {
+	for _, k := range keys {
+		if k.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Plain-text SSH connection before any keys are in use
+type sshConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Connect, exchange version strings and read the server's KEXINIT payload
+func sshHandshake(addr string) (*sshConn, string, []byte, error) {
+	conn, err := dialTCP(addr, sshTimeout)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	conn.SetDeadline(time.Now().Add(sshTimeout))
+	c := &sshConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	if _, err := io.WriteString(conn, "SSH-2.0-netshell_1.0\r\n"); err != nil {
+		conn.Close()
+		return nil, "", nil, err
+	}
+	// Servers may send other lines before the version string
+	var version string
+	for i := 0; i < 20; i++ {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, "", nil, err
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			version = strings.TrimRight(line, "\r\n")
+			break
+		}
+	}
+	if version == "" {
+		conn.Close()
+		return nil, "", nil, errors.New("no SSH version string")
+	}
+
+	payload, err := c.readPacket()
+	if err != nil || len(payload) == 0 || payload[0] != sshMsgKexInit {
+		conn.Close()
+		return nil, "", nil, fmt.Errorf("expected KEXINIT: %v", err)
+	}
+	return c, version, payload, nil
+}
+
+func sshAlgorithms(addr string) (*SSHInfo, []byte, error) {
+	c, version, kexinit, err := sshHandshake(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Close()
+
+	lists, err := parseKexInit(kexinit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &SSHInfo{
+		Version:     version,
+		Kex:         lists[0],
+		HostKeyAlgs: lists[1],
+		Ciphers:     lists[2],
+		MACs:        lists[4],
+		Compression: lists[6],
+	}, kexinit, nil
+}
+
+// Split a KEXINIT payload into its ten name-lists
+func parseKexInit(payload []byte) ([][]string, error) {
+	if len(payload) < 17 {
+		return nil, errors.New("short KEXINIT")
+	}
+	data := payload[17:] // message type and 16 byte cookie
+	lists := make([][]string, 10)
+	for i := range lists {
+		s, rest, err := readSSHString(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(s) > 0 {
+			lists[i] = strings.Split(string(s), ",")
+		}
+		data = rest
+	}
+	return lists, nil
+}
+
+// Run an ECDH key exchange offering only hostKeyAlg and return the host key
+// the server sends with its reply
+func sshHostKey(addr string, serverKexInit []byte, hostKeyAlg string) (SSHHostKey, error) {
+	lists, err := parseKexInit(serverKexInit)
+	if err != nil {
+		return SSHHostKey{}, err
+	}
+	kex := ""
+	for _, k := range sshKexSupported {
+		if hasString(lists[0], k) {
+			kex = k
+			break
+		}
+	}
+	if kex == "" {
+		return SSHHostKey{}, errors.New("no supported key exchange")
+	}
+
+	c, _, _, err := sshHandshake(addr)
+	if err != nil {
+		return SSHHostKey{}, err
+	}
+	defer c.Close()
+
+	// Offer the server's own cipher, MAC and compression lists so negotiation cannot fail
+	msg := []byte{sshMsgKexInit}
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+	msg = append(msg, cookie...)
+	ours := [][]string{{kex}, {hostKeyAlg}, lists[2], lists[3], lists[4], lists[5], lists[6], lists[7], lists[8], lists[9]}
+	for _, list := range ours {
+		msg = appendSSHString(msg, []byte(strings.Join(list, ",")))
+	}
+	msg = append(msg, 0, 0, 0, 0, 0) // first_kex_packet_follows, reserved
+	if err := c.writePacket(msg); err != nil {
+		return SSHHostKey{}, err
+	}
+
+	curve := ecdh.X25519()
+	if kex == "ecdh-sha2-nistp256" {
+		curve = ecdh.P256()
+	}
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return SSHHostKey{}, err
+	}
+	if err := c.writePacket(appendSSHString([]byte{sshMsgKexECDHInit}, priv.PublicKey().Bytes())); err != nil {
+		return SSHHostKey{}, err
+	}
+
+	for {
+		payload, err := c.readPacket()
+		if err != nil {
+			return SSHHostKey{}, err
+		}
+		if len(payload) == 0 || payload[0] != sshMsgKexECDHReply {
+			continue
+		}
+		blob, _, err := readSSHString(payload[1:])
+		if err != nil {
+			return SSHHostKey{}, err
+		}
+		keyType, _, err := readSSHString(blob)
+		if err != nil {
+			return SSHHostKey{}, err
+		}
+		sum := sha256.Sum256(blob)
+		return SSHHostKey{Type: string(keyType), Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])}, nil
+	}
+}
+
+// Read one unencrypted binary packet and return its payload
+func (c *sshConn) readPacket() ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	padding := uint32(header[4])
+	if length < padding+1 || length > 256*1024 {
+		return nil, errors.New("bad SSH packet length")
+	}
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	return body[:length-1-padding], nil
+}
+
+// Write one unencrypted binary packet, padded to a multiple of 8 bytes
+func (c *sshConn) writePacket(payload []byte) error {
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	_, err := c.Write(packet)
+	return err
+}
+
+func readSSHString(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("short SSH string")
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < n {
+		return nil, nil, errors.New("short SSH string")
+	}
+	return data[4 : 4+n], data[4+n:], nil
+}
+
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,80 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(fd int) (*syscall.Termios, error) {
+	var t syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+func setTermios(fd int, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Whether f is a terminal
+func isTerminal(f *os.File) bool {
+	_, err := getTermios(int(f.Fd()))
+	return err == nil
+}
+
+// Deliver keypresses one at a time without echo, returning a function that
+// restores the previous mode. Output processing is left alone so "\n" still
+// starts a new line.
+func makeRaw(f *os.File) (restore func(), err error) {
+	fd := int(f.Fd())
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := setTermios(fd, &raw); err != nil {
+		return nil, err
+	}
+	return func() { setTermios(fd, old) }, nil
+}
+
+// Like makeRaw, but Ctrl-C still sends a signal and reads return nothing after
+// a tenth of a second without input, so a reader can poll for keypresses
+func makeCbreak(f *os.File) (restore func(), err error) {
+	fd := int(f.Fd())
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	cbreak := *old
+	cbreak.Lflag &^= syscall.ECHO | syscall.ICANON
+	cbreak.Cc[syscall.VMIN] = 0
+	cbreak.Cc[syscall.VTIME] = 1
+	if err := setTermios(fd, &cbreak); err != nil {
+		return nil, err
+	}
+	return func() { setTermios(fd, old) }, nil
+}
+
+// Whether f is a terminal this process may read from: one whose foreground
+// process group is ours
+func isForeground(f *os.File) bool {
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return false
+	}
+	return int(pgrp) == syscall.Getpgrp()
+}
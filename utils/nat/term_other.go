@@ -0,0 +1,30 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Whether f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Raw terminal mode is only implemented on Linux; the shell falls back to
+// reading whole lines, without completion or history navigation.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func makeCbreak(f *os.File) (restore func(), err error) {
+	return nil, errors.New("cbreak terminal mode is not supported on this platform")
+}
+
+// Key handling needs cbreak mode, which only Linux supports, so no terminal
+// counts as ours
+func isForeground(f *os.File) bool {
+	return false
+}
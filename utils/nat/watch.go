@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Where watch mode sends the changes it finds
+type watchSinks struct {
+	logPath string
+	webhook string
+}
+
+// Rescan every interval and report only what changed since the previous run.
+// baseline may be nil, in which case the first scan only sets the baseline.
+func watchScans(interval time.Duration, baseline *ScanResult, scan func() *ScanResult, sinks watchSinks) error {
+	prev := baseline
+	for {
+		cur := scan()
+		if prev != nil {
+			diff := diffResults(prev, cur)
+			if !diff.empty() {
+				if err := sinks.emit(diff); err != nil {
+					fmt.Fprintln(os.Stderr, "Error reporting changes:", err)
+				}
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "Baseline for %s: %d host(s) up\n", cur.Target, len(cur.Hosts))
+		}
+		prev = cur
+		time.Sleep(interval)
+	}
+}
+
+// Send a diff to stdout, the log file and the webhook. A failing sink does
+// not keep the diff from the others; their errors are returned together.
+func (s watchSinks) emit(diff *ScanDiff) error {
+	var errs []error
+	if err := writeDiff(os.Stdout, diff); err != nil {
+		errs = append(errs, fmt.Errorf("writing to stdout: %w", err))
+	}
+	if s.logPath != "" {
+		if err := appendDiff(s.logPath, diff); err != nil {
+			errs = append(errs, fmt.Errorf("writing %s: %w", s.logPath, err))
+		}
+	}
+	if s.webhook != "" {
+		if err := postDiff(s.webhook, diff); err != nil {
+			errs = append(errs, fmt.Errorf("posting to webhook: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func appendDiff(path string, diff *ScanDiff) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = writeDiff(file, diff)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// POST the diff as JSON to a webhook URL
+func postDiff(url string, diff *ScanDiff) error {
+	body, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
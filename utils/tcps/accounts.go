@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hashIterations = 200000
+	minPasswordLen = 8
+	loginAttempts  = 3
+)
+
+// A registered user
+type account struct {
+	Salt       string    `json:"salt"`
+	Hash       string    `json:"hash"`
+	Iterations int       `json:"iterations"`
+	Created    time.Time `json:"created"`
+}
+
+var (
+	accountsMu   sync.Mutex
+	accounts     = make(map[string]*account) // Registered users by name
+	accountsPath string                      // File the accounts are stored in
+	allowGuests  bool                        // Whether unregistered names may chat
+)
+
+// PBKDF2 with HMAC-SHA256 (RFC 8018), producing one 32 byte block
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func newAccount(password string) (*account, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	hash := pbkdf2SHA256([]byte(password), salt, hashIterations)
+	return &account{
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Hash:       base64.StdEncoding.EncodeToString(hash),
+		Iterations: hashIterations,
+		Created:    time.Now(),
+	}, nil
+}
+
+func (a *account) verify(password string) bool {
+	salt, err1 := base64.StdEncoding.DecodeString(a.Salt)
+	want, err2 := base64.StdEncoding.DecodeString(a.Hash)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got := pbkdf2SHA256([]byte(password), salt, a.Iterations)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// Load the accounts file; a missing file means no accounts yet
+func loadAccounts(path string) error {
+	accountsPath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &accounts)
+}
+
+// Write the accounts file atomically. Caller must hold accountsMu.
+func saveAccounts() error {
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := accountsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, accountsPath)
+}
+
+func lookupAccount(name string) *account {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	return accounts[name]
+}
+
+func isRegistered(name string) bool {
+	return lookupAccount(name) != nil
+}
+
+// Create an account and store it
+func registerAccount(name, password string) error {
+	if len(password) < minPasswordLen {
+		return fmt.Errorf("passwords need at least %d characters", minPasswordLen)
+	}
+	acc, err := newAccount(password)
+	if err != nil {
+		return err
+	}
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if accounts[name] != nil {
+		return fmt.Errorf("%s is already registered", name)
+	}
+	accounts[name] = acc
+	return saveAccounts()
+}
+
+// Create an account with a password read from stdin. This is how names
+// reserved by -ops get their account.
+func registerFromStdin(name string) error {
+	if err := validNick(name); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || password == "") {
+		return err
+	}
+	return registerAccount(name, strings.TrimSpace(password))
+}
+
+// Ask for a line at the connection prompt
+func prompt(conn net.Conn, reader *bufio.Reader, text string) (string, error) {
+	conn.Write([]byte(text))
+	line, err := readLine(reader)
+	return strings.TrimSpace(line), err
+}
+
+// Authenticate a connecting user. Registered names need their password;
+// other names join as guests, or must register first when guests are not
+// allowed. Returns whether the user may join and whether they authenticated.
+func login(conn net.Conn, reader *bufio.Reader, name string) (ok, authenticated bool) {
+	if acc := lookupAccount(name); acc != nil {
+		for attempt := 0; attempt < loginAttempts; attempt++ {
+			password, err := prompt(conn, reader, "Password: ")
+			if err != nil {
+				return false, false
+			}
+			if acc.verify(password) {
+				return true, true
+			}
+			time.Sleep(time.Second)
+			conn.Write([]byte("Wrong password.\n"))
+		}
+		fmt.Printf("* failed logins for `%s` from %s\n", name, conn.RemoteAddr())
+		return false, false
+	}
+
+	if allowGuests {
+		conn.Write([]byte("Joining as a guest. Use /register $password to claim this name.\n"))
+		return true, false
+	}
+	password, err := prompt(conn, reader, "This server requires an account. Choose a password: ")
+	if err != nil {
+		return false, false
+	}
+	if err := registerAccount(name, password); err != nil {
+		fmt.Fprintf(conn, "Registration failed: %v\n", err)
+		return false, false
+	}
+	conn.Write([]byte("Account created.\n"))
+	return true, true
+}
+
+// Mark a session as authenticated. Caller must hold mu.
+func setAuthenticated(conn net.Conn) {
+	info := clientData[conn]
+	info.authenticated = true
+	clientData[conn] = info
+}
+
+// Register the sender's current name
+func registerUser(password string, conn net.Conn) {
+	mu.Lock()
+	name := clients[conn]
+	mu.Unlock()
+	if err := registerAccount(name, password); err != nil {
+		fmt.Fprintf(conn, "Registration failed: %v\n", err)
+		return
+	}
+	mu.Lock()
+	setAuthenticated(conn)
+	mu.Unlock()
+	fmt.Fprintf(conn, "%s is now registered. Log in with this password next time.\n", name)
+}
+
+// Change the sender's password
+func changePassword(oldPassword, newPassword string, conn net.Conn) {
+	mu.Lock()
+	name := clients[conn]
+	mu.Unlock()
+	acc := lookupAccount(name)
+	if acc == nil {
+		fmt.Fprintln(conn, "You are not registered.")
+		return
+	}
+	if !acc.verify(oldPassword) {
+		fmt.Fprintln(conn, "Wrong password.")
+		return
+	}
+	if len(newPassword) < minPasswordLen {
+		fmt.Fprintf(conn, "Passwords need at least %d characters.\n", minPasswordLen)
+		return
+	}
+	updated, err := newAccount(newPassword)
+	if err != nil {
+		fmt.Fprintln(conn, "Error changing password.")
+		return
+	}
+	updated.Created = acc.Created
+	accountsMu.Lock()
+	accounts[name] = updated
+	err = saveAccounts()
+	accountsMu.Unlock()
+	if err != nil {
+		fmt.Fprintln(conn, "Error saving password.")
+		return
+	}
+	fmt.Fprintln(conn, "Password changed.")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Flood protection thresholds; zero disables a limit
+var (
+	rateLimit     = 5.0             // Lines per second a user may send on average
+	rateBurst     = 10              // Lines a user may send at once
+	maxLineLength = 2048            // Longest line accepted, in bytes
+	maxConnsPerIP = 5               // Simultaneous connections from one address
+	muteAfter     = 3               // Strikes before a user is muted
+	muteDuration  = 1 * time.Minute // How long a mute lasts
+	kickAfter     = 10              // Strikes before a user is kicked
+)
+
+var errLineTooLong = errors.New("line too long")
+
+// Token bucket and abuse record of a user, shared by their sessions
+type floodState struct {
+	tokens     float64
+	last       time.Time
+	strikes    int // Lines over the limit since the user last calmed down
+	mutedUntil time.Time
+	warned     bool // Told about the current mute already
+}
+
+var (
+	floods     = make(map[string]*floodState) // By username
+	connsPerIP = make(map[string]int)         // Open connections by address
+)
+
+// Take a token, refilling the bucket for the time since the last line.
+// A full bucket means the user calmed down, so their strikes are forgiven.
+func (f *floodState) take(now time.Time) bool {
+	f.tokens += now.Sub(f.last).Seconds() * rateLimit
+	f.last = now
+	if f.tokens >= float64(rateBurst) {
+		f.tokens = float64(rateBurst)
+		f.strikes = 0
+	}
+	if f.tokens < 1 {
+		return false
+	}
+	f.tokens--
+	return true
+}
+
+// Whether a line from a client may be handled. Lines over the rate limit
+// are dropped and count as strikes; enough strikes mute the user, and
+// more while muted kick them. Lines still buffered from a kicked client
+// are never handled.
+func allowLine(conn net.Conn) bool {
+	mu.Lock()
+	name, connected := clients[conn]
+	if !connected || rateLimit <= 0 {
+		mu.Unlock()
+		return connected
+	}
+	now := time.Now()
+	f := floods[name]
+	if f == nil {
+		f = &floodState{tokens: float64(rateBurst), last: now}
+		floods[name] = f
+	}
+
+	muted := now.Before(f.mutedUntil)
+	if !muted && !f.mutedUntil.IsZero() {
+		f.mutedUntil, f.strikes = time.Time{}, 0
+	}
+	if !muted && f.take(now) {
+		mu.Unlock()
+		return true
+	}
+
+	f.strikes++
+	switch {
+	case kickAfter > 0 && f.strikes >= kickAfter:
+		fmt.Fprintln(conn, "(!) You have been kicked for flooding.")
+		audit("server", conn, "kick "+name, "flooding")
+		kicked := disconnectUser(name) > 0
+		mu.Unlock()
+		if kicked {
+			messages <- chatMessage{text: fmt.Sprintf("%s was kicked from the server for flooding.", name)}
+		}
+		return false
+	case muted:
+		if !f.warned {
+			fmt.Fprintf(conn, "(!) You are muted for another %s.\n", time.Until(f.mutedUntil).Round(time.Second))
+			f.warned = true
+		}
+	case muteAfter > 0 && f.strikes >= muteAfter:
+		f.mutedUntil, f.warned = now.Add(muteDuration), false
+		fmt.Fprintf(conn, "(!) You are muted for %s for flooding.\n", muteDuration)
+		audit("server", conn, "mute "+name, "flooding")
+	default:
+		fmt.Fprintln(conn, "(!) You are sending too fast, message dropped.")
+	}
+	mu.Unlock()
+	return false
+}
+
+// Forget the flood record of a user whose last session ended, unless
+// they are muted. Caller must hold mu.
+func forgetFlood(name string) {
+	if f := floods[name]; f != nil && !time.Now().Before(f.mutedUntil) && len(sessionsOf(name)) == 0 {
+		delete(floods, name)
+	}
+}
+
+// Count a new connection from ip, refusing it when the address has too many
+func addConnection(ip string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if maxConnsPerIP > 0 && connsPerIP[ip] >= maxConnsPerIP {
+		return false
+	}
+	connsPerIP[ip]++
+	return true
+}
+
+func removeConnection(ip string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if connsPerIP[ip]--; connsPerIP[ip] <= 0 {
+		delete(connsPerIP, ip)
+	}
+}
+
+// Read a line of at most maxLineLength bytes. A longer line is discarded
+// up to its end and errLineTooLong returned, so the reader never grows.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLength && maxLineLength > 0 {
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = reader.ReadSlice('\n')
+			}
+			if err != nil {
+				return "", err
+			}
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return string(line), err
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	type result struct {
+		line string
+		err  error
+	}
+	long := strings.Repeat("x", 40)
+	tests := []struct {
+		name  string
+		limit int
+		input string
+		want  []result // Successive readLine results
+	}{
+		{"short line", 10, "hello\n", []result{{"hello\n", nil}, {"", io.EOF}}},
+		{"exactly the limit", 10, "123456789\n", []result{{"123456789\n", nil}}},
+		{"one byte over", 10, "1234567890\n", []result{{"", errLineTooLong}, {"", io.EOF}}},
+		{"line after a long one", 10, long + "\nok\n", []result{{"", errLineTooLong}, {"ok\n", nil}}},
+		{"long line filling the buffer", 20, long + "\n" + long[:19] + "\n", []result{{"", errLineTooLong}, {long[:19] + "\n", nil}}},
+		{"empty lines", 10, "\n\n", []result{{"\n", nil}, {"\n", nil}, {"", io.EOF}}},
+		{"no newline at EOF", 10, "abc", []result{{"abc", io.EOF}}},
+		{"too long at EOF", 10, long, []result{{"", io.EOF}}},
+		{"empty input", 10, "", []result{{"", io.EOF}}},
+		{"no limit", 0, long + long + "\n", []result{{long + long + "\n", nil}}},
+	}
+	defer func(limit int) { maxLineLength = limit }(maxLineLength)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxLineLength = tt.limit
+			// The smallest buffer bufio allows, so long lines arrive in pieces
+			reader := bufio.NewReaderSize(strings.NewReader(tt.input), 16)
+			for i, want := range tt.want {
+				line, err := readLine(reader)
+				if line != want.line || !errors.Is(err, want.err) {
+					t.Fatalf("read %d: got %q, %v; want %q, %v", i, line, err, want.line, want.err)
+				}
+			}
+		})
+	}
+}
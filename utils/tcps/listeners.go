@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A listener the server accepts clients on. Closing it stops new
+// connections only; clients it already accepted stay connected.
+type managedListener struct {
+	id       int
+	tls      bool
+	listener net.Listener
+	opened   time.Time
+	accepted int
+}
+
+var (
+	listenersMu    sync.Mutex
+	listeners      = make(map[int]*managedListener) // Open listeners by id
+	nextListenerID = 1
+	serverTLS      *tls.Config // Configuration for TLS listeners, nil when TLS is off
+)
+
+func (l *managedListener) String() string {
+	kind := "tcp"
+	if l.tls {
+		kind = "tls"
+	}
+	return fmt.Sprintf("#%d %s %s", l.id, kind, l.listener.Addr())
+}
+
+// Turn "host:port" into a listen address. host may be an interface name,
+// which stands for its first IPv4 address (or first address when it has no
+// IPv4 one), or empty for every interface.
+func resolveListenAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		// Not an interface, let Listen resolve it as a host name
+		return addr, nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	var ip net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			if n.IP.To4() != nil {
+				ip = n.IP
+				break
+			}
+			if ip == nil && !n.IP.IsLinkLocalUnicast() {
+				ip = n.IP
+			}
+		}
+	}
+	if ip == nil {
+		return "", fmt.Errorf("interface %s has no usable address", host)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// First private IPv4 address of this machine, for /localnet
+func localNetworkIP() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil && n.IP.IsPrivate() {
+			return n.IP.String(), nil
+		}
+	}
+	return "", errors.New("no private IPv4 address found")
+}
+
+// Open a listener and start accepting clients on it
+func openListener(addr string, useTLS bool) (*managedListener, error) {
+	if useTLS && serverTLS == nil {
+		return nil, errors.New("TLS is not configured, start the server with -tls-port")
+	}
+	addr, err := resolveListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	var listener net.Listener
+	if useTLS {
+		listener, err = tls.Listen("tcp", addr, serverTLS)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	listenersMu.Lock()
+	l := &managedListener{id: nextListenerID, tls: useTLS, listener: listener, opened: time.Now()}
+	nextListenerID++
+	listeners[l.id] = l
+	listenersMu.Unlock()
+
+	log.Printf("Listening on %s\n", l)
+	go acceptClients(l)
+	return l, nil
+}
+
+// Stop accepting clients on a listener
+func closeListener(l *managedListener) {
+	listenersMu.Lock()
+	delete(listeners, l.id)
+	listenersMu.Unlock()
+	l.listener.Close()
+	log.Printf("Closed listener %s\n", l)
+}
+
+// Open listeners sorted by id
+func openListeners() []*managedListener {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	var list []*managedListener
+	for _, l := range listeners {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// Find an open listener by id ("#2"), address ("127.0.0.1:8080") or port
+// ("8080" or ":8080"). A bare number is tried as an id first.
+func findListener(s string) *managedListener {
+	list := openListeners()
+	for _, l := range list {
+		if strings.TrimPrefix(s, "#") == fmt.Sprint(l.id) {
+			return l
+		}
+	}
+	if !strings.Contains(s, ":") {
+		s = ":" + s
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil
+	}
+	for _, l := range list {
+		lhost, lport, _ := net.SplitHostPort(l.listener.Addr().String())
+		if port == lport && (host == "" || host == lhost) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Accept connections until the listener is closed
+func acceptClients(l *managedListener) {
+	for {
+		conn, err := l.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error accepting connection on %s: %v\n", l, err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		listenersMu.Lock()
+		l.accepted++
+		listenersMu.Unlock()
+		go handleClient(conn)
+	}
+}
+
+// Move every listener to host, keeping its port and kind. Each listener is
+// closed before its replacement opens, since a wildcard and a specific
+// address cannot share a port; if the new one fails the old one is reopened.
+func rebindListeners(host string, conn net.Conn) {
+	list := openListeners()
+	if len(list) == 0 {
+		fmt.Fprintln(conn, "No listeners are open. Use /listen to open one.")
+		return
+	}
+	for _, old := range list {
+		_, port, _ := net.SplitHostPort(old.listener.Addr().String())
+		addr := net.JoinHostPort(host, port)
+		closeListener(old)
+		l, err := openListener(addr, old.tls)
+		if err != nil {
+			fmt.Fprintf(conn, "Error listening on %s: %v\n", addr, err)
+			if l, err = openListener(old.listener.Addr().String(), old.tls); err != nil {
+				fmt.Fprintf(conn, "Error reopening %s: %v\n", old.listener.Addr(), err)
+				continue
+			}
+		}
+		fmt.Fprintf(conn, "Server hosting on %s\n", l.listener.Addr())
+	}
+}
+
+// Open a listener from "/listen [tls] [host]:port"
+func listen(args []string, conn net.Conn) {
+	useTLS := len(args) > 0 && args[0] == "tls"
+	if useTLS {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(conn, "Usage: /listen [tls] [$host|$interface]:$port")
+		return
+	}
+	addr := args[0]
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	l, err := openListener(addr, useTLS)
+	if err != nil {
+		fmt.Fprintf(conn, "Error listening on %s: %v\n", addr, err)
+		return
+	}
+	fmt.Fprintf(conn, "Listening on %s\n", l)
+}
+
+// Close a listener from "/unlisten $id|$address"
+func unlisten(args []string, conn net.Conn) {
+	if len(args) != 1 {
+		fmt.Fprintln(conn, "Usage: /unlisten $id|$address")
+		return
+	}
+	l := findListener(args[0])
+	if l == nil {
+		fmt.Fprintln(conn, "No such listener.")
+		return
+	}
+	closeListener(l)
+	fmt.Fprintf(conn, "Closed %s; connected clients stay connected.\n", l)
+}
+
+// List the open listeners
+func listListeners(conn net.Conn) {
+	list := openListeners()
+	if len(list) == 0 {
+		fmt.Fprintln(conn, "No listeners are open.")
+		return
+	}
+	fmt.Fprintln(conn, "Listeners:")
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	for _, l := range list {
+		fmt.Fprintf(conn, "%s, open since %s, %d connection(s) accepted\n",
+			l, l.opened.Format(time.RFC1123), l.accepted)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	minNickLength = 2
+	maxNickLength = 20
+	nickAttempts  = 3 // Names a connecting client may try
+)
+
+// Names nobody may take unless an account of that name already exists
+var reservedNames = map[string]bool{}
+
+// Set the reserved names from a comma-separated list
+func setReservedNames(list string) {
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			reservedNames[strings.ToLower(name)] = true
+		}
+	}
+}
+
+// Check the form of a nickname: ASCII letters, digits, '_', '-' and '.',
+// starting with a letter, so names cannot imitate each other with
+// lookalike characters or hide inside chat lines
+func validNick(name string) error {
+	if len(name) < minNickLength || len(name) > maxNickLength {
+		return fmt.Errorf("names need %d to %d characters", minNickLength, maxNickLength)
+	}
+	for i, c := range name {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if i == 0 && !letter {
+			return errors.New("names start with a letter")
+		}
+		if !letter && !(c >= '0' && c <= '9') && !strings.ContainsRune("_-.", c) {
+			return errors.New("names may only contain letters, digits, '_', '-' and '.'")
+		}
+	}
+	return nil
+}
+
+// Registered account whose name differs from name only in case, if any
+func accountLike(name string) string {
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	for registered := range accounts {
+		if registered != name && strings.EqualFold(registered, name) {
+			return registered
+		}
+	}
+	return ""
+}
+
+// Whether another connection uses a name, ignoring case. Caller must hold mu.
+func nickInUse(name string, except net.Conn) bool {
+	for client, other := range clients {
+		if client != except && strings.EqualFold(other, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that conn may use a name: well formed, not reserved, not a
+// lookalike of an account and not in use. Caller must hold mu.
+func checkNick(name string, conn net.Conn) error {
+	if err := validNick(name); err != nil {
+		return err
+	}
+	if reservedNames[strings.ToLower(name)] && !isRegistered(name) {
+		return fmt.Errorf("%s is a reserved name", name)
+	}
+	if registered := accountLike(name); registered != "" {
+		return fmt.Errorf("%s is too close to the registered name %s", name, registered)
+	}
+	if nickInUse(name, conn) {
+		return fmt.Errorf("%s is already in use", name)
+	}
+	return nil
+}
+
+// Ask a connecting client for a name until it passes checkNick, giving up
+// after nickAttempts tries
+func promptNick(conn net.Conn, reader *bufio.Reader) (string, bool) {
+	for i := 0; i < nickAttempts; i++ {
+		name, err := prompt(conn, reader, "Enter your name: ")
+		if err != nil {
+			return "", false
+		}
+		mu.Lock()
+		err = checkNick(name, conn)
+		mu.Unlock()
+		if err == nil {
+			return name, true
+		}
+		fmt.Fprintf(conn, "Cannot use that name: %v.\n", err)
+	}
+	return "", false
+}
+
+// Change the sender's nickname and tell everyone. The new name is not an
+// account the sender logged in to, so they continue as a guest.
+func changeNick(name string, conn net.Conn) {
+	mu.Lock()
+	old := clients[conn]
+	if name == old {
+		mu.Unlock()
+		fmt.Fprintf(conn, "You are already %s.\n", name)
+		return
+	}
+	if err := checkNick(name, conn); err != nil {
+		mu.Unlock()
+		fmt.Fprintf(conn, "Cannot change name: %v.\n", err)
+		return
+	}
+	if isRegistered(name) {
+		mu.Unlock()
+		fmt.Fprintf(conn, "%s is registered; reconnect and log in to use it.\n", name)
+		return
+	}
+	if _, banned := userBan(name); banned {
+		mu.Unlock()
+		fmt.Fprintf(conn, "Cannot change name: %s is banned.\n", name)
+		return
+	}
+
+	// Room op rights and flood records follow the person, not the old
+	// name; as a guest the session keeps its rights only while connected
+	for _, r := range rooms {
+		if r.isOp(conn) {
+			r.guestOps[conn] = true
+		}
+	}
+	clients[conn] = name
+	info := clientData[conn]
+	wasAuthenticated := info.authenticated
+	info.username, info.authenticated = name, false
+	clientData[conn] = info
+	if f := floods[old]; f != nil {
+		floods[name] = f
+		delete(floods, old)
+	}
+	appendLog(old, fmt.Sprintf("%s is now known as %s", old, name))
+	appendLog(name, fmt.Sprintf("%s was known as %s", name, old))
+	mu.Unlock()
+
+	if wasAuthenticated {
+		fmt.Fprintf(conn, "You are now a guest as %s; reconnect as %s to use your account.\n", name, old)
+	}
+	messages <- chatMessage{text: fmt.Sprintf("* `%s` is now known as `%s`", old, name)}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidNick(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"al", true},
+		{"alice", true},
+		{"Alice_99", true},
+		{"a.b-c_d", true},
+		{strings.Repeat("a", maxNickLength), true},
+		{"", false},
+		{"a", false},
+		{strings.Repeat("a", maxNickLength+1), false},
+		{"9lives", false},
+		{"_alice", false},
+		{".alice", false},
+		{"-alice", false},
+		{"al ice", false},
+		{"alice\t", false},
+		{"alice\n", false},
+		{"al:ice", false},
+		{"al/ice", false},
+		{"[alice]", false},
+		{"`alice`", false},
+		{"аlice", false}, // Cyrillic a
+		{"alicé", false},
+		{"ali\x00ce", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validNick(tt.name)
+			if (err == nil) != tt.valid {
+				t.Fatalf("validNick(%q) = %v, want valid %v", tt.name, err, tt.valid)
+			}
+		})
+	}
+}
+
+// A chat client for tests, reading the server's lines
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialTestClient(t *testing.T, addr, name string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	c.send(name)
+	c.expect(fmt.Sprintf("* `%s` has joined the chat!", name))
+	return c
+}
+
+func (c *testClient) send(line string) {
+	if _, err := fmt.Fprintln(c.conn, line); err != nil {
+		c.t.Fatalf("send %q: %v", line, err)
+	}
+}
+
+// Read lines until one contains want
+func (c *testClient) expect(want string) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		line, err := c.r.ReadString('\n')
+		if strings.Contains(line, want) {
+			return
+		}
+		if err != nil {
+			c.t.Fatalf("waiting for %q: %v", want, err)
+		}
+	}
+}
+
+func TestNickChangeUsesNewName(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+	defer func(guests bool) { allowGuests = guests }(allowGuests)
+	allowGuests = true
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleClient(conn)
+		}
+	}()
+	go func() {
+		for msg := range messages {
+			broadcastMessage(msg)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	alice := dialTestClient(t, addr, "alice")
+	bob := dialTestClient(t, addr, "bob")
+
+	alice.send("/nick alicia")
+	bob.expect("* `alice` is now known as `alicia`")
+	alice.send("hello")
+	bob.expect("[#lobby] alicia: hello")
+	alice.conn.Close()
+	bob.expect("* `alicia` has left the chat.")
+	bob.conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	entries := strings.Join(clientLog["alicia"], "\n")
+	if !strings.Contains(entries, "[#lobby] alicia: hello") {
+		t.Errorf("log of alicia lacks the chat line: %q", entries)
+	}
+	if old := strings.Join(clientLog["alice"], "\n"); strings.Contains(old, "hello") {
+		t.Errorf("chat line logged under the old name: %q", old)
+	}
+}
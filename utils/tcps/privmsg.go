@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const maxQueuedMessages = 100
+
+// A private message waiting for its offline recipient
+type privateMessage struct {
+	From string    `json:"from"`
+	Text string    `json:"text"`
+	Sent time.Time `json:"sent"`
+}
+
+var (
+	mailbox     = make(map[string][]privateMessage) // Queued messages by recipient
+	mailboxPath string                              // File the queue is stored in
+)
+
+// Load queued private messages; a missing file means an empty queue
+func loadMailbox(path string) error {
+	mailboxPath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &mailbox)
+}
+
+// Write the queue atomically. Caller must hold mu.
+func saveMailbox() error {
+	data, err := json.MarshalIndent(mailbox, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := mailboxPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, mailboxPath)
+}
+
+// Sessions of a user. Caller must hold mu.
+func sessionsOf(username string) []net.Conn {
+	var conns []net.Conn
+	for client, name := range clients {
+		if name == username {
+			conns = append(conns, client)
+		}
+	}
+	return conns
+}
+
+// Remember who to answer with /reply. Caller must hold mu.
+func setReplyTo(conn net.Conn, username string) {
+	info := clientData[conn]
+	info.replyTo = username
+	clientData[conn] = info
+}
+
+// Send a private message, queueing it when a registered recipient is offline
+func sendPrivate(to, text string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	from := clients[conn]
+	if to == from {
+		fmt.Fprintln(conn, "You cannot message yourself.")
+		return
+	}
+
+	if recipients := sessionsOf(to); len(recipients) > 0 {
+		for _, r := range recipients {
+			fmt.Fprintf(r, "[PM from %s] %s\n", from, text)
+			setReplyTo(r, from)
+		}
+		fmt.Fprintf(conn, "[PM to %s] %s (delivered)\n", to, text)
+		return
+	}
+
+	if !isRegistered(to) {
+		fmt.Fprintf(conn, "%s is not online.\n", to)
+		return
+	}
+	if len(mailbox[to]) >= maxQueuedMessages {
+		fmt.Fprintf(conn, "%s is offline and has too many queued messages.\n", to)
+		return
+	}
+	mailbox[to] = append(mailbox[to], privateMessage{From: from, Text: text, Sent: time.Now()})
+	if err := saveMailbox(); err != nil {
+		log.Printf("Error saving mailbox: %v\n", err)
+	}
+	fmt.Fprintf(conn, "[PM to %s] %s (queued, %s is offline)\n", to, text, to)
+}
+
+// Answer the last user who sent you a private message
+func replyPrivate(text string, conn net.Conn) {
+	mu.Lock()
+	to := clientData[conn].replyTo
+	mu.Unlock()
+	if to == "" {
+		fmt.Fprintln(conn, "Nobody to reply to.")
+		return
+	}
+	sendPrivate(to, text, conn)
+}
+
+// Show a user the messages queued while they were offline, and tell the
+// senders that are online that their messages arrived
+func deliverQueued(conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	name := clients[conn]
+	queued := mailbox[name]
+	if len(queued) == 0 || !clientData[conn].authenticated {
+		return
+	}
+
+	fmt.Fprintf(conn, "You have %d message(s) received while you were away:\n", len(queued))
+	senders := make(map[string]int)
+	for _, m := range queued {
+		fmt.Fprintf(conn, "[PM from %s, %s] %s\n", m.From, m.Sent.Format(time.RFC1123), m.Text)
+		senders[m.From]++
+	}
+	setReplyTo(conn, queued[len(queued)-1].From)
+	delete(mailbox, name)
+	if err := saveMailbox(); err != nil {
+		log.Printf("Error saving mailbox: %v\n", err)
+	}
+
+	for from, n := range senders {
+		for _, s := range sessionsOf(from) {
+			fmt.Fprintf(s, "* %d queued message(s) to %s delivered.\n", n, name)
+		}
+	}
+}
+
+// Join the words of a command from index i, keeping the text as typed
+func restOf(command string, i int) string {
+	fields := strings.Fields(command)
+	if i >= len(fields) {
+		return ""
+	}
+	rest := command
+	for _, f := range fields[:i] {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		rest = strings.TrimPrefix(rest, f)
+	}
+	return strings.TrimSpace(rest)
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestRestOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		i       int
+		want    string
+	}{
+		{"message", "/msg bob hello there", 2, "hello there"},
+		{"reply", "/reply hello there", 1, "hello there"},
+		{"inner spacing kept", "/msg bob hello   there", 2, "hello   there"},
+		{"extra spaces", "  /msg   bob    hello  ", 2, "hello"},
+		{"tabs", "/msg\tbob\thello\tthere", 2, "hello\tthere"},
+		{"other whitespace", "/msg\u00a0bob\u00a0hello", 2, "hello"},
+		{"text repeats the name", "/msg bob bob is here", 2, "bob is here"},
+		{"name inside a word", "/msg bo bob", 2, "bob"},
+		{"no text", "/msg bob", 2, ""},
+		{"only spaces after", "/msg bob    ", 2, ""},
+		{"beyond the fields", "/msg", 5, ""},
+		{"empty command", "", 1, ""},
+		{"whole command", " /msg bob hi ", 0, "/msg bob hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restOf(tt.command, tt.i); got != tt.want {
+				t.Fatalf("restOf(%q, %d) = %q, want %q", tt.command, tt.i, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Privilege level of a user
+type role int
+
+const (
+	roleUser role = iota
+	roleOperator
+)
+
+func (r role) String() string {
+	if r == roleOperator {
+		return "operator"
+	}
+	return "user"
+}
+
+// Role needed for each command; commands not listed are open to everyone
+var commandRoles = map[string]role{
+	"/hostall":     roleOperator,
+	"/localhost":   roleOperator,
+	"/localnet":    roleOperator,
+	"/listen":      roleOperator,
+	"/unlisten":    roleOperator,
+	"/listeners":   roleOperator,
+	"/kick":        roleOperator,
+	"/ban":         roleOperator,
+	"/unban":       roleOperator,
+	"/log":         roleOperator,
+	"/connections": roleOperator,
+	"/blockip":     roleOperator,
+	"/whitelistip": roleOperator,
+	"/unblockip":   roleOperator,
+	"/bans":        roleOperator,
+	"/save":        roleOperator,
+	"/op":          roleOperator,
+	"/deop":        roleOperator,
+	"/audit":       roleOperator,
+}
+
+const auditHistory = 200
+
+var (
+	operators       = make(map[string]time.Time) // Usernames with operator rights and when they got them
+	configOperators = make(map[string]bool)      // Operators from -ops, which are not saved with the state
+	opPassword      string                       // Password for /oper, empty disables it
+	auditLogger     *log.Logger                  // Audit log file, nil if disabled
+	auditTrail      []string                     // Recent audit entries for /audit
+)
+
+// Load the configured operators and open the audit log. Their names are
+// reserved, so until an account exists nobody can take them.
+func setupRoles(ops, password, auditPath string) error {
+	for _, name := range strings.Split(ops, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			operators[name] = time.Now()
+			configOperators[name] = true
+			reservedNames[strings.ToLower(name)] = true
+		}
+	}
+	opPassword = password
+	if auditPath == "" {
+		return nil
+	}
+	file, err := os.OpenFile(auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	auditLogger = log.New(file, "", log.LstdFlags)
+	return nil
+}
+
+// Role of a connected session. Operator rights belong to an account: they
+// apply only to a session logged in to an account that already existed when
+// the name was made operator, so a guest using an operator's name, or
+// registering it afterwards, gets none. Caller must hold mu.
+func sessionRole(conn net.Conn) role {
+	name := clients[conn]
+	granted, ok := operators[name]
+	if !ok || !clientData[conn].authenticated {
+		return roleUser
+	}
+	if acc := lookupAccount(name); acc == nil || acc.Created.After(granted) {
+		return roleUser
+	}
+	return roleOperator
+}
+
+// Record an admin action. Caller must hold mu.
+func audit(actor string, conn net.Conn, action, outcome string) {
+	entry := fmt.Sprintf("%s (%s) %s: %s", actor, conn.RemoteAddr(), action, outcome)
+	if auditLogger != nil {
+		auditLogger.Println(entry)
+	}
+	auditTrail = append(auditTrail, time.Now().Format(time.RFC3339)+" "+entry)
+	if len(auditTrail) > auditHistory {
+		auditTrail = auditTrail[len(auditTrail)-auditHistory:]
+	}
+}
+
+// Check that the sender may run the command, telling them when not.
+// Privileged commands are audited either way.
+func authorize(command string, conn net.Conn) bool {
+	args := strings.Fields(command)
+	required, privileged := commandRoles[args[0]]
+	if !privileged {
+		return true
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	name := clients[conn]
+	if sessionRole(conn) < required {
+		if roomScopedCommands[args[0]] && len(args) > 1 && isRoomOp(args[1], conn) {
+			audit(name, conn, command, "allowed as room op")
+			return true
+		}
+		audit(name, conn, command, "denied")
+		fmt.Fprintf(conn, "Permission denied: %s requires %s rights.\n", args[0], required)
+		return false
+	}
+	audit(name, conn, command, "allowed")
+	return true
+}
+
+// Make the sender's account an operator with the server's operator password
+func operLogin(password string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	name := clients[conn]
+	if !clientData[conn].authenticated || !isRegistered(name) {
+		audit(name, conn, "/oper", "refused, not logged in")
+		fmt.Fprintln(conn, "Log in to a registered account to use /oper.")
+		return
+	}
+	if opPassword == "" || subtle.ConstantTimeCompare([]byte(password), []byte(opPassword)) != 1 {
+		audit(name, conn, "/oper", "failed")
+		fmt.Fprintln(conn, "Permission denied: wrong operator password.")
+		return
+	}
+	operators[name] = time.Now()
+	persist()
+	audit(name, conn, "/oper", "granted")
+	fmt.Fprintln(conn, "You are now an operator.")
+}
+
+// Grant operator rights to a registered user
+func opUser(username string, conn net.Conn) {
+	if !isRegistered(username) {
+		fmt.Fprintf(conn, "%s is not registered; only registered users can be operators.\n", username)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	operators[username] = time.Now()
+	persist()
+	fmt.Fprintf(conn, "%s is now an operator.\n", username)
+	for client, name := range clients {
+		if name == username && client != conn && clientData[client].authenticated {
+			fmt.Fprintf(client, "%s made you an operator.\n", clients[conn])
+		}
+	}
+}
+
+// Take operator rights away from a user
+func deopUser(username string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := operators[username]; !ok {
+		fmt.Fprintf(conn, "%s is not an operator.\n", username)
+		return
+	}
+	delete(operators, username)
+	persist()
+	fmt.Fprintf(conn, "%s is no longer an operator.\n", username)
+	for client, name := range clients {
+		if name == username && client != conn {
+			fmt.Fprintf(client, "%s removed your operator rights.\n", clients[conn])
+		}
+	}
+}
+
+// List the operators and whether they are online
+func listOperators(conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	online := make(map[string]bool)
+	for _, name := range clients {
+		online[name] = true
+	}
+	fmt.Fprintln(conn, "Operators:")
+	for name := range operators {
+		if online[name] {
+			fmt.Fprintf(conn, "%s (online)\n", name)
+		} else {
+			fmt.Fprintln(conn, name)
+		}
+	}
+}
+
+// Show the most recent audit entries
+func showAudit(conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	fmt.Fprintln(conn, "Audit log:")
+	for _, entry := range auditTrail {
+		fmt.Fprintln(conn, entry)
+	}
+}
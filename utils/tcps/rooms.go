@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultRoom = "#lobby"
+
+// A chat room. Its ops moderate it without needing server operator rights.
+type room struct {
+	name     string
+	topic    string
+	created  time.Time
+	members  map[net.Conn]bool
+	ops      map[string]bool   // Room operators by account name
+	guestOps map[net.Conn]bool // Room operators without an account, by session
+	banned   map[string]bool   // Usernames that may not join
+}
+
+// A message for the members of a room, or everyone when room is empty
+type chatMessage struct {
+	room string
+	text string
+}
+
+var rooms = map[string]*room{
+	defaultRoom: newRoom(defaultRoom),
+}
+
+func newRoom(name string) *room {
+	return &room{
+		name:     name,
+		created:  time.Now(),
+		members:  make(map[net.Conn]bool),
+		ops:      make(map[string]bool),
+		guestOps: make(map[net.Conn]bool),
+		banned:   make(map[string]bool),
+	}
+}
+
+func isRoomName(s string) bool {
+	return len(s) > 1 && len(s) <= 32 && strings.HasPrefix(s, "#") && !strings.ContainsAny(s[1:], "# ,")
+}
+
+// Rooms a connection is in, sorted. Caller must hold mu.
+func roomsOf(conn net.Conn) []string {
+	var names []string
+	for name, r := range rooms {
+		if r.members[conn] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Room that a connection's messages go to, "" when it is in none. Caller must hold mu.
+func activeRoom(conn net.Conn) string {
+	name := clientData[conn].room
+	if r := rooms[name]; r != nil && r.members[conn] {
+		return name
+	}
+	return ""
+}
+
+func setActiveRoom(conn net.Conn, name string) {
+	info := clientData[conn]
+	info.room = name
+	clientData[conn] = info
+}
+
+// Account a session is logged in to, "" for guests. Caller must hold mu.
+func accountOf(conn net.Conn) string {
+	if name := clients[conn]; clientData[conn].authenticated && isRegistered(name) {
+		return name
+	}
+	return ""
+}
+
+// Whether a session holds op rights in a room: through the account it is
+// logged in to, or as the guest session they were given to, so nobody gets
+// them by reconnecting under an op's name. Caller must hold mu.
+func (r *room) isOp(conn net.Conn) bool {
+	if account := accountOf(conn); account != "" && r.ops[account] {
+		return true
+	}
+	return r.guestOps[conn]
+}
+
+// Make a session a room op. Caller must hold mu.
+func (r *room) addOp(conn net.Conn) {
+	if account := accountOf(conn); account != "" {
+		r.ops[account] = true
+	} else {
+		r.guestOps[conn] = true
+	}
+}
+
+// Whether a user moderates a room, as room op or server operator. Caller must hold mu.
+func isRoomOp(name string, conn net.Conn) bool {
+	r := rooms[name]
+	return r != nil && (r.isOp(conn) || sessionRole(conn) >= roleOperator)
+}
+
+// Send a line to every member of a room. Caller must hold mu.
+func tellRoom(r *room, text string) {
+	for member := range r.members {
+		fmt.Fprintln(member, text)
+	}
+}
+
+// Join a room, creating it (with the creator as room op) if needed, and make
+// it the active room. Joining a room you are already in just switches to it.
+func joinRoom(name string, conn net.Conn) {
+	if !isRoomName(name) {
+		fmt.Fprintln(conn, "Room names start with # and have no spaces, e.g. #general.")
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	username := clients[conn]
+	r := rooms[name]
+	if r == nil {
+		r = newRoom(name)
+		r.addOp(conn)
+		rooms[name] = r
+	}
+	if r.banned[username] {
+		fmt.Fprintf(conn, "You are banned from %s.\n", name)
+		return
+	}
+	setActiveRoom(conn, name)
+	if r.members[conn] {
+		fmt.Fprintf(conn, "Now talking in %s.\n", name)
+		return
+	}
+	r.members[conn] = true
+	tellRoom(r, fmt.Sprintf("* `%s` joined %s", username, name))
+	if r.topic != "" {
+		fmt.Fprintf(conn, "Topic for %s: %s\n", name, r.topic)
+	}
+}
+
+// Remove a connection from a room, deleting the room once it is empty.
+// Caller must hold mu.
+func removeFromRoom(r *room, conn net.Conn) {
+	delete(r.members, conn)
+	if len(r.members) == 0 && r.name != defaultRoom {
+		delete(rooms, r.name)
+	}
+	if clientData[conn].room == r.name {
+		// Fall back to another room the user is still in
+		next := ""
+		if names := roomsOf(conn); len(names) > 0 {
+			next = names[0]
+		}
+		setActiveRoom(conn, next)
+	}
+}
+
+// Leave a room, the active one when name is empty
+func partRoom(name string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" {
+		name = activeRoom(conn)
+	}
+	r := rooms[name]
+	if r == nil || !r.members[conn] {
+		fmt.Fprintln(conn, "You are not in that room.")
+		return
+	}
+	tellRoom(r, fmt.Sprintf("* `%s` left %s", clients[conn], name))
+	removeFromRoom(r, conn)
+	if next := activeRoom(conn); next != "" {
+		fmt.Fprintf(conn, "Now talking in %s.\n", next)
+	} else {
+		fmt.Fprintln(conn, "You are not in any room. Use /join #room to talk.")
+	}
+}
+
+// Take a disconnecting client out of every room. Caller must hold mu.
+func leaveAllRooms(conn net.Conn) {
+	for _, r := range rooms {
+		delete(r.guestOps, conn)
+		if r.members[conn] {
+			removeFromRoom(r, conn)
+		}
+	}
+}
+
+// List the rooms with their member counts and topics
+func listRooms(conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(conn, "Rooms:")
+	for _, name := range names {
+		r := rooms[name]
+		line := fmt.Sprintf("%s (%d)", name, len(r.members))
+		if r.topic != "" {
+			line += " - " + r.topic
+		}
+		fmt.Fprintln(conn, line)
+	}
+}
+
+// List the members of a room, room ops marked with @
+func listRoomMembers(name string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" {
+		name = activeRoom(conn)
+	}
+	r := rooms[name]
+	if r == nil {
+		fmt.Fprintln(conn, "No such room.")
+		return
+	}
+	var members []string
+	for member := range r.members {
+		username := clients[member]
+		if r.isOp(member) {
+			username = "@" + username
+		}
+		members = append(members, username)
+	}
+	sort.Strings(members)
+	fmt.Fprintf(conn, "Members of %s:\n", name)
+	for _, m := range members {
+		fmt.Fprintln(conn, m)
+	}
+}
+
+// Show or, for room ops, set the topic of a room
+func roomTopic(args []string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	name := activeRoom(conn)
+	if len(args) > 0 && isRoomName(args[0]) {
+		name, args = args[0], args[1:]
+	}
+	r := rooms[name]
+	if r == nil {
+		fmt.Fprintln(conn, "No such room.")
+		return
+	}
+	if len(args) == 0 {
+		if r.topic == "" {
+			fmt.Fprintf(conn, "%s has no topic.\n", name)
+		} else {
+			fmt.Fprintf(conn, "Topic for %s: %s\n", name, r.topic)
+		}
+		return
+	}
+	if !isRoomOp(name, conn) {
+		fmt.Fprintf(conn, "Permission denied: only ops of %s can change its topic.\n", name)
+		return
+	}
+	r.topic = strings.Join(args, " ")
+	tellRoom(r, fmt.Sprintf("* `%s` set the topic of %s: %s", clients[conn], name, r.topic))
+}
+
+// Kick every session of a user out of a room
+func kickFromRoom(name, username string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	r := rooms[name]
+	if r == nil {
+		fmt.Fprintln(conn, "No such room.")
+		return
+	}
+	var kicked []net.Conn
+	for member := range r.members {
+		if clients[member] == username {
+			kicked = append(kicked, member)
+		}
+	}
+	if len(kicked) == 0 {
+		fmt.Fprintln(conn, "User not found in room.")
+		return
+	}
+	tellRoom(r, fmt.Sprintf("%s was kicked from %s by %s.", username, name, clients[conn]))
+	for _, member := range kicked {
+		removeFromRoom(r, member)
+	}
+}
+
+// Ban a user from a room, kicking them if present
+func banFromRoom(name, username string, conn net.Conn) {
+	mu.Lock()
+	r := rooms[name]
+	if r == nil {
+		mu.Unlock()
+		fmt.Fprintln(conn, "No such room.")
+		return
+	}
+	r.banned[username] = true
+	fmt.Fprintf(conn, "%s has been banned from %s.\n", username, name)
+	mu.Unlock()
+	kickFromRoom(name, username, conn)
+}
+
+func unbanFromRoom(name, username string, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	if r := rooms[name]; r != nil {
+		delete(r.banned, username)
+	}
+	fmt.Fprintf(conn, "%s has been unbanned from %s.\n", username, name)
+}
+
+// Grant or revoke room operator rights
+func setRoomOp(name, username string, grant bool, conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	r := rooms[name]
+	if r == nil {
+		fmt.Fprintln(conn, "No such room.")
+		return
+	}
+	// Registered users get the rights on their account, guests on the
+	// sessions they have now
+	if grant {
+		if isRegistered(username) {
+			r.ops[username] = true
+		} else {
+			sessions := sessionsOf(username)
+			if len(sessions) == 0 {
+				fmt.Fprintf(conn, "%s is not registered and not online.\n", username)
+				return
+			}
+			for _, session := range sessions {
+				r.guestOps[session] = true
+			}
+		}
+		tellRoom(r, fmt.Sprintf("* `%s` made %s an op of %s", clients[conn], username, name))
+	} else {
+		delete(r.ops, username)
+		for session := range r.guestOps {
+			if clients[session] == username {
+				delete(r.guestOps, session)
+			}
+		}
+		tellRoom(r, fmt.Sprintf("* `%s` removed %s as op of %s", clients[conn], username, name))
+	}
+}
+
+// Commands that act on a single room when their first argument is a room
+// name. Room ops may run them there without server operator rights.
+var roomScopedCommands = map[string]bool{
+	"/kick":  true,
+	"/ban":   true,
+	"/unban": true,
+	"/op":    true,
+	"/deop":  true,
+}
+
+// Run a room-scoped form of a moderation command, e.g. "/kick #room user".
+// Returns false when the command is not room-scoped.
+func handleRoomCommand(args []string, conn net.Conn) bool {
+	if !roomScopedCommands[args[0]] || len(args) < 2 || !isRoomName(args[1]) {
+		return false
+	}
+	if len(args) < 3 {
+		fmt.Fprintf(conn, "Usage: %s #room $username\n", args[0])
+		return true
+	}
+	name, username := args[1], args[2]
+	switch args[0] {
+	case "/kick":
+		kickFromRoom(name, username, conn)
+	case "/ban":
+		banFromRoom(name, username, conn)
+	case "/unban":
+		unbanFromRoom(name, username, conn)
+	case "/op":
+		setRoomOp(name, username, true, conn)
+	case "/deop":
+		setRoomOp(name, username, false, conn)
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How output to clients is queued and written
+var (
+	sendQueueSize      = 1024             // Lines queued per client
+	writeTimeout       = 10 * time.Second // Time allowed for one write
+	slowConsumerPolicy = "disconnect"     // What to do when a queue is full: drop or disconnect
+	droppedMessages    atomic.Int64       // Lines dropped for slow clients
+)
+
+var errSlowConsumer = errors.New("send queue full")
+
+// A client connection whose writes go through a bounded queue drained by its
+// own goroutine, so a client that stops reading cannot stall whoever writes
+// to it, even while holding mu
+type clientConn struct {
+	net.Conn
+	mu      sync.Mutex
+	queue   chan []byte
+	closed  bool
+	dropped int // Lines dropped since the client was last told
+}
+
+func newClientConn(conn net.Conn) *clientConn {
+	c := &clientConn{Conn: conn, queue: make(chan []byte, sendQueueSize)}
+	go c.writeLoop()
+	return c
+}
+
+// Queue p for the client. A full queue drops p or disconnects the client,
+// depending on slowConsumerPolicy.
+func (c *clientConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if c.dropped > 0 && len(c.queue) < cap(c.queue)-1 {
+		c.queue <- []byte(fmt.Sprintf("(!) %d message(s) were dropped because you were not reading fast enough.\n", c.dropped))
+		c.dropped = 0
+	}
+	select {
+	case c.queue <- append([]byte(nil), p...):
+		return len(p), nil
+	default:
+	}
+
+	droppedMessages.Add(1)
+	if slowConsumerPolicy == "drop" {
+		c.dropped++
+		return len(p), nil
+	}
+	log.Printf("Disconnecting %s: %v\n", c.RemoteAddr(), errSlowConsumer)
+	c.shutdown()
+	c.Conn.Close()
+	return 0, errSlowConsumer
+}
+
+// Stop accepting writes and wake up the reader. Queued lines are still
+// written before the connection closes. Caller must hold c.mu.
+func (c *clientConn) shutdown() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.queue)
+	c.Conn.SetReadDeadline(time.Now())
+}
+
+// Close the connection once the queued lines are written
+func (c *clientConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shutdown()
+	return nil
+}
+
+// Write queued lines until the queue is closed or a write fails
+func (c *clientConn) writeLoop() {
+	defer c.Conn.Close()
+	for p := range c.queue {
+		c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := c.Conn.Write(p); err != nil {
+			c.Close()
+			c.Conn.Close()
+			for range c.queue {
+				// Discard what is left
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxLogEntries    = 1000             // Entries kept per user log
+	logFlushInterval = 30 * time.Second // How often changed logs are written
+)
+
+// Why, by whom and until when a user or address is banned
+type ban struct {
+	Reason  string    `json:"reason,omitempty"`
+	By      string    `json:"by"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"` // Zero for a permanent ban
+}
+
+func (b ban) expired() bool {
+	return !b.Expires.IsZero() && time.Now().After(b.Expires)
+}
+
+// "until <time>: reason" or "permanently: reason"
+func (b ban) String() string {
+	s := "permanently"
+	if !b.Expires.IsZero() {
+		s = "until " + b.Expires.Format(time.RFC1123)
+	}
+	if b.Reason != "" {
+		s += ": " + b.Reason
+	}
+	return s
+}
+
+// Everything the server keeps across restarts, as stored on disk
+type serverState struct {
+	Bans       map[string]ban      `json:"bans"`
+	BlockedIPs map[string]ban      `json:"blocked_ips"` // Keyed by IP or CIDR range
+	Whitelist  []string            `json:"whitelist"`
+	Operators  []string            `json:"operators"`
+	Logs       map[string][]string `json:"logs"`
+}
+
+var (
+	statePath string // File the state is stored in, empty to keep it in memory
+	logsDirty bool   // Logs changed since they were last written
+)
+
+// Load the saved state; a missing file means a fresh server
+func loadState(path string) error {
+	statePath = path
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st serverState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	for name, b := range st.Bans {
+		blockedUsers[banKey(name)] = b
+	}
+	for addr, b := range st.BlockedIPs {
+		blockedIPs[addr] = b
+	}
+	for _, addr := range st.Whitelist {
+		whitelistedIPs[addr] = true
+	}
+	// Saved grants count from now, so only accounts that already exist
+	// get the rights
+	for _, name := range st.Operators {
+		if _, ok := operators[name]; !ok {
+			operators[name] = time.Now()
+		}
+	}
+	for name, entries := range st.Logs {
+		clientLog[name] = entries
+	}
+	return nil
+}
+
+// Write the state atomically. Caller must hold mu.
+func saveState() error {
+	if statePath == "" {
+		return nil
+	}
+	st := serverState{
+		Bans:       blockedUsers,
+		BlockedIPs: blockedIPs,
+		Logs:       clientLog,
+	}
+	for addr := range whitelistedIPs {
+		st.Whitelist = append(st.Whitelist, addr)
+	}
+	// Operators from -ops come from the command line on every start
+	for name := range operators {
+		if !configOperators[name] {
+			st.Operators = append(st.Operators, name)
+		}
+	}
+	sort.Strings(st.Whitelist)
+	sort.Strings(st.Operators)
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, statePath); err != nil {
+		return err
+	}
+	logsDirty = false
+	return nil
+}
+
+// Save the state, logging failures. Caller must hold mu.
+func persist() {
+	if err := saveState(); err != nil {
+		log.Printf("Error saving state: %v\n", err)
+	}
+}
+
+// Write changed logs every logFlushInterval
+func flushLogs() {
+	for range time.Tick(logFlushInterval) {
+		mu.Lock()
+		if logsDirty {
+			persist()
+		}
+		mu.Unlock()
+	}
+}
+
+// Add an entry to a user's log, keeping the newest maxLogEntries.
+// Caller must hold mu.
+func appendLog(name, entry string) {
+	entries := append(clientLog[name], entry)
+	if len(entries) > maxLogEntries {
+		entries = entries[len(entries)-maxLogEntries:]
+	}
+	clientLog[name] = entries
+	logsDirty = true
+}
+
+// Key of a user's ban. Names are unique ignoring case, so a ban on "Bob"
+// also covers "bob".
+func banKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// The ban on a user, forgetting it once it has expired. Caller must hold mu.
+func userBan(name string) (ban, bool) {
+	b, ok := blockedUsers[banKey(name)]
+	if ok && b.expired() {
+		delete(blockedUsers, banKey(name))
+		persist()
+		return ban{}, false
+	}
+	return b, ok
+}
+
+// Whether ip equals an address or falls in a CIDR range
+func addrMatches(pattern string, ip net.IP) bool {
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		return network.Contains(ip)
+	}
+	return net.ParseIP(pattern).Equal(ip)
+}
+
+// The block stopping ip from connecting, forgetting expired ones. Blocks
+// apply even inside whitelisted ranges; once the whitelist has entries every
+// address outside it is blocked too. Caller must hold mu.
+func ipBlock(ip net.IP) (ban, bool) {
+	for addr, b := range blockedIPs {
+		if b.expired() {
+			delete(blockedIPs, addr)
+			persist()
+			continue
+		}
+		if addrMatches(addr, ip) {
+			return b, true
+		}
+	}
+	for addr := range whitelistedIPs {
+		if addrMatches(addr, ip) {
+			return ban{}, false
+		}
+	}
+	if len(whitelistedIPs) > 0 {
+		return ban{Reason: "not whitelisted"}, true
+	}
+	return ban{}, false
+}
+
+// Check an IP address or CIDR range, returning it in canonical form
+func parseAddrPattern(s string) (string, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network.String(), nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("%q is not an IP address or CIDR range", s)
+}
+
+// Parse a ban length such as 90m, 2h, 3d or 1w
+func parseBanDuration(s string) (time.Duration, bool) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) && n > 0 && n <= int(math.MaxInt64/unit) {
+			return time.Duration(n) * unit, true
+		}
+	}
+	d, err := time.ParseDuration(s)
+	return d, err == nil && d > 0
+}
+
+// Build a ban from "[$duration] [$reason...]" as given to /ban and /blockip
+func newBan(args []string, by string) ban {
+	b := ban{By: by, Created: time.Now()}
+	if len(args) > 0 {
+		if d, ok := parseBanDuration(args[0]); ok {
+			b.Expires = b.Created.Add(d)
+			args = args[1:]
+		}
+	}
+	b.Reason = strings.Join(args, " ")
+	return b
+}
+
+// List bans, IP blocks and the whitelist
+func listBans(conn net.Conn) {
+	mu.Lock()
+	defer mu.Unlock()
+	show := func(title string, bans map[string]ban) {
+		var keys []string
+		for k, b := range bans {
+			if !b.expired() {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(conn, "%s (%d):\n", title, len(keys))
+		for _, k := range keys {
+			b := bans[k]
+			fmt.Fprintf(conn, "%s - by %s on %s, %s\n", k, b.By, b.Created.Format(time.RFC1123), b)
+		}
+	}
+	show("Banned users", blockedUsers)
+	show("Blocked addresses", blockedIPs)
+	var whitelist []string
+	for addr := range whitelistedIPs {
+		whitelist = append(whitelist, addr)
+	}
+	sort.Strings(whitelist)
+	fmt.Fprintf(conn, "Whitelist (%d): %s\n", len(whitelist), strings.Join(whitelist, ", "))
+}
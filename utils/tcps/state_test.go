@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBanDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration // Zero when the input is not a ban length
+	}{
+		{"90m", 90 * time.Minute},
+		{"2h", 2 * time.Hour},
+		{"1h30m", 90 * time.Minute},
+		{"10s", 10 * time.Second},
+		{"3d", 3 * 24 * time.Hour},
+		{"1w", 7 * 24 * time.Hour},
+		{"52w", 52 * 7 * 24 * time.Hour},
+		{"", 0},
+		{"d", 0},
+		{"w", 0},
+		{"0d", 0},
+		{"0s", 0},
+		{"-1d", 0},
+		{"-5m", 0},
+		{"1.5d", 0},
+		{"3dd", 0},
+		{"d3", 0},
+		{"3 d", 0},
+		{"1y", 0},
+		{"spamming", 0},
+		{"99999999999999w", 0},
+		{"99999999999999h", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := parseBanDuration(tt.in)
+			if ok != (tt.want > 0) || ok && got != tt.want {
+				t.Fatalf("parseBanDuration(%q) = %v, %v; want %v", tt.in, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserBanIgnoresCase(t *testing.T) {
+	defer func(saved map[string]ban) { blockedUsers = saved }(blockedUsers)
+	blockedUsers = map[string]ban{}
+	blockedUsers[banKey("Bob")] = ban{By: "admin", Created: time.Now()}
+	blockedUsers[banKey("Old")] = ban{By: "admin", Created: time.Now(), Expires: time.Now().Add(-time.Minute)}
+
+	for _, name := range []string{"Bob", "bob", "BOB"} {
+		if _, banned := userBan(name); !banned {
+			t.Errorf("userBan(%q) = not banned, want banned", name)
+		}
+	}
+	for _, name := range []string{"bobby", "old", "Old"} {
+		if _, banned := userBan(name); banned {
+			t.Errorf("userBan(%q) = banned, want not banned", name)
+		}
+	}
+}
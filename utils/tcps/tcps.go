@@ -2,92 +2,168 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var (
-	port           string
 	mu             sync.Mutex
-	clients        = make(map[net.Conn]string) // Connected clients
-	clientLog      = make(map[string][]string) // Logs for each client
-	totalMessages  int                         // Count of total messages sent
-	startTime      = time.Now()                // Server start time
-	messages       = make(chan string)         // Channel for broadcast messages
-	blockedUsers   = make(map[string]bool)     // Blocked users
-	blockedIPs     = make(map[string]bool)     // Blocked IPs
-	whitelistedIPs = make(map[string]bool)     // Whitelisted IPs
+	clients        = make(map[net.Conn]string)  // Connected clients
+	clientLog      = make(map[string][]string)  // Logs for each client
+	totalMessages  atomic.Int64                 // Count of total messages sent
+	startTime      = time.Now()                 // Server start time
+	messages       = make(chan chatMessage, 64) // Channel for broadcast messages
+	blockedUsers   = make(map[string]ban)       // Blocked users
+	blockedIPs     = make(map[string]ban)       // Blocked IPs and CIDR ranges
+	whitelistedIPs = make(map[string]bool)      // Whitelisted IPs and CIDR ranges
 )
 
 type clientInfo struct {
-	username    string
-	connectTime time.Time
+	username      string
+	connectTime   time.Time
+	authenticated bool   // Logged in to a registered account or by client certificate
+	room          string // Room the client's messages go to
+	replyTo       string // Last user who sent a private message, for /reply
 }
 
 // Active client connections with metadata
 var clientData = make(map[net.Conn]clientInfo)
 
-// Broadcast a message to all clients
-func broadcastMessage(message string) {
+// Broadcast a message to the members of its room, or all clients
+func broadcastMessage(message chatMessage) {
 	mu.Lock()
 	defer mu.Unlock()
+	if message.room != "" {
+		if r := rooms[message.room]; r != nil {
+			tellRoom(r, message.text)
+		}
+		return
+	}
 	for client := range clients {
-		fmt.Fprintln(client, message)
+		fmt.Fprintln(client, message.text)
 	}
 }
 
 // Handle client connections and commands
-func handleClient(conn net.Conn) {
+func handleClient(raw net.Conn) {
+	conn := newClientConn(raw)
 	defer conn.Close()
 
 	// Check if IP is blocked or not whitelisted
-	ip := conn.RemoteAddr().(*net.TCPAddr).IP.String()
-	if blockedIPs[ip] || (len(whitelistedIPs) > 0 && !whitelistedIPs[ip]) {
-		conn.Write([]byte("Your IP is blocked or not whitelisted.\n"))
+	ip := conn.RemoteAddr().(*net.TCPAddr).IP
+	mu.Lock()
+	block, blocked := ipBlock(ip)
+	mu.Unlock()
+	if blocked {
+		fmt.Fprintf(conn, "Your IP is blocked or not whitelisted (%s).\n", block)
+		return
+	}
+	if !addConnection(ip.String()) {
+		fmt.Fprintf(conn, "Too many connections from your IP (at most %d).\n", maxConnsPerIP)
+		return
+	}
+	defer removeConnection(ip.String())
+
+	// A verified client certificate names the user
+	name, err := certificateName(raw)
+	if err != nil {
+		log.Printf("TLS handshake with %s failed: %v\n", conn.RemoteAddr(), err)
 		return
 	}
+	authenticated := name != ""
 
 	reader := bufio.NewReader(conn)
-	conn.Write([]byte("Enter your name: "))
-	name, _ := reader.ReadString('\n')
-	name = strings.TrimSpace(name)
+	if authenticated {
+		mu.Lock()
+		err = checkNick(name, conn)
+		mu.Unlock()
+		if err != nil {
+			fmt.Fprintf(conn, "Cannot use the name of your certificate: %v.\n", err)
+			return
+		}
+		fmt.Fprintf(conn, "Authenticated as %s by client certificate.\n", name)
+	} else {
+		var ok bool
+		if name, ok = promptNick(conn, reader); !ok {
+			return
+		}
+	}
 
 	// Check if user is blocked
-	if blockedUsers[name] {
-		conn.Write([]byte("(!) You are blocked from this server.\n"))
-		fmt.Printf(fmt.Sprintf("* `%s` is blocked but still tryed to login at %d", name, time.Now().Format(time.RFC1123)))
+	mu.Lock()
+	b, banned := userBan(name)
+	mu.Unlock()
+	if banned {
+		fmt.Fprintf(conn, "(!) You are blocked from this server %s.\n", b)
+		fmt.Printf("* `%s` is blocked but still tryed to login at %s\n", name, time.Now().Format(time.RFC1123))
 		return
 	}
 
+	if !authenticated {
+		var ok bool
+		if ok, authenticated = login(conn, reader, name); !ok {
+			return
+		}
+	}
+
 	mu.Lock()
+	// The name may have been taken while this client was logging in
+	if nickInUse(name, conn) {
+		mu.Unlock()
+		fmt.Fprintf(conn, "%s is already in use.\n", name)
+		return
+	}
 	clients[conn] = name
-	clientData[conn] = clientInfo{username: name, connectTime: time.Now()}
-	clientLog[name] = append(clientLog[name], fmt.Sprintf("%s joined at %s", name, time.Now().Format(time.RFC1123)))
+	clientData[conn] = clientInfo{username: name, connectTime: time.Now(), authenticated: authenticated}
+	appendLog(name, fmt.Sprintf("%s joined at %s", name, time.Now().Format(time.RFC1123)))
+	if lobby := rooms[defaultRoom]; !lobby.banned[name] {
+		lobby.members[conn] = true
+		setActiveRoom(conn, defaultRoom)
+	}
 	mu.Unlock()
 
 	// Welcome message
 	welcomeMsg := fmt.Sprintf("\n* `%s` has joined the chat!", name)
-	messages <- welcomeMsg
+	messages <- chatMessage{text: welcomeMsg}
+	deliverQueued(conn)
 
-	fmt.Printf(fmt.Sprintf("* `%s` joined at %s", name, time.Now().Format(time.RFC1123)))
+	fmt.Printf("* `%s` joined at %s\n", name, time.Now().Format(time.RFC1123))
 
 	for {
-		msg, err := reader.ReadString('\n')
-		if err != nil {
-			log.Printf("\n* `%s` disconnected.\n", name)
+		msg, err := readLine(reader)
+		if err != nil && !errors.Is(err, errLineTooLong) {
 			mu.Lock()
+			// /nick may have renamed the client; a kicked one keeps its last name
+			if current, ok := clients[conn]; ok {
+				name = current
+			}
+			log.Printf("\n* `%s` disconnected.\n", name)
+			leaveAllRooms(conn)
 			delete(clients, conn)
 			delete(clientData, conn)
+			forgetFlood(name)
 			mu.Unlock()
-			messages <- fmt.Sprintf("* `%s` has left the chat.", name)
+			messages <- chatMessage{text: fmt.Sprintf("* `%s` has left the chat.", name)}
 			return
 		}
+		if !allowLine(conn) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(conn, "(!) Line too long (at most %d bytes), ignored.\n", maxLineLength)
+			continue
+		}
 		msg = strings.TrimSpace(msg)
 
 		// Handle special commands
@@ -96,18 +172,37 @@ func handleClient(conn net.Conn) {
 			continue
 		}
 
-		fullMsg := fmt.Sprintf("%s: %s", name, msg)
 		mu.Lock()
-		clientLog[name] = append(clientLog[name], fullMsg)
+		room := activeRoom(conn)
+		current, connected := clients[conn]
 		mu.Unlock()
-		totalMessages++
-		messages <- fullMsg
+		if !connected {
+			continue
+		}
+		name = current
+		if room == "" {
+			fmt.Fprintln(conn, "You are not in any room. Use /join #room to talk.")
+			continue
+		}
+
+		fullMsg := fmt.Sprintf("[%s] %s: %s", room, name, msg)
+		mu.Lock()
+		appendLog(name, fullMsg)
+		mu.Unlock()
+		totalMessages.Add(1)
+		messages <- chatMessage{room: room, text: fullMsg}
 	}
 }
 
 // Handle server commands
 func handleCommand(command string, conn net.Conn) {
 	args := strings.Fields(command)
+	if !authorize(command, conn) {
+		return
+	}
+	if handleRoomCommand(args, conn) {
+		return
+	}
 	switch args[0] {
 	// For Hosting Commands
 	case "/hostall":
@@ -116,6 +211,12 @@ func handleCommand(command string, conn net.Conn) {
 		hostOnLocalhost(conn)
 	case "/localnet":
 		hostOnLocalNetwork(conn)
+	case "/listen":
+		listen(args[1:], conn)
+	case "/unlisten":
+		unlisten(args[1:], conn)
+	case "/listeners":
+		listListeners(conn)
 	// Forensic/Analytics Commands
 	case "/stats":
 		showStats(conn)
@@ -133,9 +234,9 @@ func handleCommand(command string, conn net.Conn) {
 		}
 	case "/ban":
 		if len(args) > 1 {
-			banUser(args[1], conn)
+			banUser(args[1], args[2:], conn)
 		} else {
-			fmt.Fprintln(conn, "Usage: /ban $username")
+			fmt.Fprintln(conn, "Usage: /ban $username [$duration] [$reason]")
 		}
 	case "/unban":
 		if len(args) > 1 {
@@ -153,18 +254,104 @@ func handleCommand(command string, conn net.Conn) {
 		showConnections(conn)
 	case "/blockip":
 		if len(args) > 1 {
-			blockIP(args[1], conn)
+			blockIP(args[1], args[2:], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /blockip $IP|$CIDR [$duration] [$reason]")
+		}
+	case "/unblockip":
+		if len(args) > 1 {
+			unblockIP(args[1], conn)
 		} else {
-			fmt.Fprintln(conn, "Usage: /blockip $IP")
+			fmt.Fprintln(conn, "Usage: /unblockip $IP|$CIDR")
 		}
 	case "/whitelistip":
 		if len(args) > 1 {
 			whitelistIP(args[1], conn)
 		} else {
-			fmt.Fprintln(conn, "Usage: /whitelistip $IP")
+			fmt.Fprintln(conn, "Usage: /whitelistip $IP|$CIDR")
 		}
+	case "/bans":
+		listBans(conn)
 	case "/save":
 		saveLogsToFile(conn)
+	// Operator Commands
+	case "/oper":
+		if len(args) > 1 {
+			operLogin(args[1], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /oper $password")
+		}
+	case "/op":
+		if len(args) > 1 {
+			opUser(args[1], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /op $username")
+		}
+	case "/deop":
+		if len(args) > 1 {
+			deopUser(args[1], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /deop $username")
+		}
+	case "/ops":
+		listOperators(conn)
+	// Room Commands
+	case "/join":
+		if len(args) > 1 {
+			joinRoom(args[1], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /join #room")
+		}
+	case "/part":
+		if len(args) > 1 {
+			partRoom(args[1], conn)
+		} else {
+			partRoom("", conn)
+		}
+	case "/rooms":
+		listRooms(conn)
+	case "/who":
+		if len(args) > 1 {
+			listRoomMembers(args[1], conn)
+		} else {
+			listRoomMembers("", conn)
+		}
+	case "/topic":
+		roomTopic(args[1:], conn)
+	// Private Messages
+	case "/msg":
+		if text := restOf(command, 2); text != "" {
+			sendPrivate(args[1], text, conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /msg $username $text")
+		}
+	case "/reply":
+		if text := restOf(command, 1); text != "" {
+			replyPrivate(text, conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /reply $text")
+		}
+	case "/nick":
+		if len(args) > 1 {
+			changeNick(args[1], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /nick $name")
+		}
+	// Account Commands
+	case "/register":
+		if len(args) > 1 {
+			registerUser(args[1], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /register $password")
+		}
+	case "/passwd":
+		if len(args) > 2 {
+			changePassword(args[1], args[2], conn)
+		} else {
+			fmt.Fprintln(conn, "Usage: /passwd $old $new")
+		}
+	case "/audit":
+		showAudit(conn)
 	default:
 		fmt.Fprintln(conn, "Unknown command.")
 	}
@@ -173,8 +360,11 @@ func handleCommand(command string, conn net.Conn) {
 // Show server stats
 func showStats(conn net.Conn) {
 	uptime := time.Since(startTime)
-	stats := fmt.Sprintf("Server has been running for %s\nTotal Messages Sent: %d\nTotal Clients Connected: %d\n",
-		uptime, totalMessages, len(clients))
+	mu.Lock()
+	connected := len(clients)
+	mu.Unlock()
+	stats := fmt.Sprintf("Server has been running for %s\nTotal Messages Sent: %d\nTotal Clients Connected: %d\nMessages Dropped for Slow Clients: %d\n",
+		uptime, totalMessages.Load(), connected, droppedMessages.Load())
 	fmt.Fprintln(conn, stats)
 }
 
@@ -186,7 +376,7 @@ func showUptime(conn net.Conn) {
 
 // Show total message count
 func showMessageCount(conn net.Conn) {
-	fmt.Fprintf(conn, "Total messages sent: %d\n", totalMessages)
+	fmt.Fprintf(conn, "Total messages sent: %d\n", totalMessages.Load())
 }
 
 // List active users
@@ -199,45 +389,62 @@ func listUsers(conn net.Conn) {
 	}
 }
 
-// Kick a specific user
-func kickUser(username string, conn net.Conn) {
-	mu.Lock()
-	defer mu.Unlock()
+// Disconnect every session of a user, returning how many there were.
+// Caller must hold mu.
+func disconnectUser(username string) int {
+	n := 0
 	for client, name := range clients {
 		if name == username {
 			client.Close()
+			leaveAllRooms(client)
 			delete(clients, client)
 			delete(clientData, client)
-			messages <- fmt.Sprintf("%s was kicked from the server.", username)
-			return
+			n++
 		}
 	}
-	fmt.Fprintln(conn, "User not found.")
+	return n
 }
 
-// Ban a specific user
-func banUser(username string, conn net.Conn) {
+// Kick a specific user
+func kickUser(username string, conn net.Conn) {
 	mu.Lock()
-	defer mu.Unlock()
-	blockedUsers[username] = true
-	fmt.Fprintf(conn, "%s has been banned.\n", username)
-	// Kick the user if they're currently connected
+	kicked := disconnectUser(username) > 0
+	mu.Unlock()
+	// Announce without holding mu, the broadcaster needs it
+	if kicked {
+		messages <- chatMessage{text: fmt.Sprintf("%s was kicked from the server.", username)}
+	} else {
+		fmt.Fprintln(conn, "User not found.")
+	}
+}
+
+// Ban a specific user, for a time when args starts with a duration
+func banUser(username string, args []string, conn net.Conn) {
+	mu.Lock()
+	b := newBan(args, clients[conn])
+	blockedUsers[banKey(username)] = b
+	persist()
+	fmt.Fprintf(conn, "%s has been banned %s.\n", username, b)
+	// Kick the user if they're currently connected, under any case of the name
+	kicked := false
 	for client, name := range clients {
-		if name == username {
-			client.Close()
-			delete(clients, client)
-			delete(clientData, client)
-			messages <- fmt.Sprintf("%s was banned and kicked from the server.", username)
-			return
+		if strings.EqualFold(name, username) {
+			fmt.Fprintf(client, "(!) You have been banned from this server %s.\n", b)
+			kicked = disconnectUser(name) > 0 || kicked
 		}
 	}
+	mu.Unlock()
+	if kicked {
+		messages <- chatMessage{text: fmt.Sprintf("%s was banned and kicked from the server.", username)}
+	}
 }
 
 // Unban a specific user
 func unbanUser(username string, conn net.Conn) {
 	mu.Lock()
 	defer mu.Unlock()
-	delete(blockedUsers, username)
+	delete(blockedUsers, banKey(username))
+	persist()
 	fmt.Fprintf(conn, "%s has been unbanned.\n", username)
 }
 
@@ -267,29 +474,67 @@ func showConnections(conn net.Conn) {
 	}
 }
 
-// Block an IP from connecting to the server
-func blockIP(ip string, conn net.Conn) {
+// Block an IP or CIDR range from connecting to the server, for a time when
+// args starts with a duration
+func blockIP(ip string, args []string, conn net.Conn) {
+	ip, err := parseAddrPattern(ip)
+	if err != nil {
+		fmt.Fprintln(conn, err)
+		return
+	}
 	mu.Lock()
-	defer mu.Unlock()
-	blockedIPs[ip] = true
-	fmt.Fprintf(conn, "IP %s has been blocked.\n", ip)
+	b := newBan(args, clients[conn])
+	blockedIPs[ip] = b
+	persist()
+	fmt.Fprintf(conn, "IP %s has been blocked %s.\n", ip, b)
 	// Kick any clients currently connected from this IP
+	var kicked []string
 	for client, info := range clientData {
-		if client.RemoteAddr().(*net.TCPAddr).IP.String() == ip {
+		if addrMatches(ip, client.RemoteAddr().(*net.TCPAddr).IP) {
+			fmt.Fprintf(client, "(!) Your IP has been blocked %s.\n", b)
 			client.Close()
+			leaveAllRooms(client)
 			delete(clients, client)
 			delete(clientData, client)
-			messages <- fmt.Sprintf("%s was kicked due to IP block.", info.username)
+			kicked = append(kicked, info.username)
 		}
 	}
+	mu.Unlock()
+	for _, name := range kicked {
+		messages <- chatMessage{text: fmt.Sprintf("%s was kicked due to IP block.", name)}
+	}
 }
 
-// Whitelist an IP to allow access to the server
+// Lift the block on an IP or CIDR range
+func unblockIP(ip string, conn net.Conn) {
+	ip, err := parseAddrPattern(ip)
+	if err != nil {
+		fmt.Fprintln(conn, err)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := blockedIPs[ip]; !ok {
+		fmt.Fprintf(conn, "IP %s is not blocked.\n", ip)
+		return
+	}
+	delete(blockedIPs, ip)
+	persist()
+	fmt.Fprintf(conn, "IP %s has been unblocked.\n", ip)
+}
+
+// Whitelist an IP or CIDR range to allow access to the server
 func whitelistIP(ip string, conn net.Conn) {
+	ip, err := parseAddrPattern(ip)
+	if err != nil {
+		fmt.Fprintln(conn, err)
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	whitelistedIPs[ip] = true
 	delete(blockedIPs, ip) // Remove from blocked list if present
+	persist()
 	fmt.Fprintf(conn, "IP %s has been whitelisted.\n", ip)
 }
 
@@ -318,50 +563,146 @@ func saveLogsToFile(conn net.Conn) {
 
 // Host the server on all interfaces (0.0.0.0)
 func hostOnAllInterfaces(conn net.Conn) {
-	port = "0.0.0.0:" + port
-	fmt.Fprintf(conn, "Server hosting on 0.0.0.0:%s\n", port)
+	rebindListeners("0.0.0.0", conn)
 }
 
 // Host the server on localhost (127.0.0.1)
 func hostOnLocalhost(conn net.Conn) {
-	port = "127.0.0.1:" + port
-	fmt.Fprintf(conn, "Server hosting on 127.0.0.1:%s\n", port)
+	rebindListeners("127.0.0.1", conn)
 }
 
-// Host the server on local network (192.168.0.1/24)
+// Host the server on this machine's local network address
 func hostOnLocalNetwork(conn net.Conn) {
-	port = "192.168.0.1:" + port
-	fmt.Fprintf(conn, "Server hosting on 192.168.0.1:%s\n", port)
-}
-
-// Start the TCP server
-func startServer() {
-	listener, err := net.Listen("tcp", ":"+port)
+	ip, err := localNetworkIP()
 	if err != nil {
-		log.Fatalf("Error starting server: %v\n", err)
+		fmt.Fprintf(conn, "Error finding the local network address: %v\n", err)
+		return
 	}
-	defer listener.Close()
+	rebindListeners(ip, conn)
+}
 
+// Start the TCP server, with a TLS listener next to the plain one when
+// tlsConfig is set, and serve until interrupted. An empty port disables
+// the plain listener.
+func startServer(port string, tlsConfig *tls.Config, tlsPort string) {
 	go func() {
 		for msg := range messages {
 			broadcastMessage(msg)
 		}
 	}()
 
-	log.Printf("Server started on port %s\n", port)
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Printf("Error accepting connection: %v\n", err)
-			continue
+	serverTLS = tlsConfig
+	if tlsConfig != nil {
+		if _, err := openListener(":"+tlsPort, true); err != nil {
+			log.Fatalf("Error starting TLS server: %v\n", err)
+		}
+	}
+	if port != "" {
+		if _, err := openListener(":"+port, false); err != nil {
+			log.Fatalf("Error starting server: %v\n", err)
 		}
-		go handleClient(conn)
 	}
+
+	// Listeners come and go at runtime, so wait for a signal instead
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	for _, l := range openListeners() {
+		closeListener(l)
+	}
+	mu.Lock()
+	persist()
+	mu.Unlock()
+	log.Println("Server stopped")
 }
 
 func main() {
-	flag.StringVar(&port, "port", "8080", "Port to listen on")
+	port := flag.String("port", "8080", "Port to listen on")
+	ops := flag.String("ops", "", "Comma-separated usernames with operator rights; they are reserved until registered with -register")
+	opPass := flag.String("op-password", os.Getenv("TCPS_OP_PASSWORD"), "Password for /oper (default $TCPS_OP_PASSWORD, empty disables /oper)")
+	auditPath := flag.String("audit", "audit.log", "File recording operator actions (empty to disable)")
+	accountsFile := flag.String("accounts", "accounts.json", "File storing registered accounts")
+	stateFile := flag.String("state", "state.json", "File storing bans, IP blocks, the whitelist, /op and /oper grants and logs (empty to keep them in memory)")
+	mailboxFile := flag.String("mailbox", "mailbox.json", "File storing private messages for offline users")
+	flag.BoolVar(&allowGuests, "guests", true, "Let unregistered names join as guests")
+	reserved := flag.String("reserved", "server,system,root,operator,moderator,everyone,nobody", "Comma-separated names only existing accounts may use")
+	flag.Float64Var(&rateLimit, "rate", rateLimit, "Lines per second a user may send on average (0 disables flood protection)")
+	flag.IntVar(&rateBurst, "burst", rateBurst, "Lines a user may send at once before the rate limit applies")
+	flag.IntVar(&maxLineLength, "max-line", maxLineLength, "Longest line accepted from a client, in bytes (0 for no limit)")
+	flag.IntVar(&maxConnsPerIP, "max-conns-per-ip", maxConnsPerIP, "Simultaneous connections allowed from one IP (0 for no limit)")
+	flag.IntVar(&muteAfter, "mute-after", muteAfter, "Lines over the rate limit before a user is muted (0 never mutes)")
+	flag.DurationVar(&muteDuration, "mute", muteDuration, "How long a flooding user stays muted")
+	flag.IntVar(&kickAfter, "kick-after", kickAfter, "Lines over the rate limit before a user is kicked (0 never kicks)")
+	flag.IntVar(&sendQueueSize, "send-queue", sendQueueSize, "Lines queued for each client before it counts as a slow consumer")
+	flag.DurationVar(&writeTimeout, "write-timeout", writeTimeout, "Time allowed for a write to a client before it is disconnected")
+	flag.StringVar(&slowConsumerPolicy, "slow-consumer", slowConsumerPolicy, "What to do when a client's send queue is full: drop or disconnect")
+	tlsPort := flag.String("tls-port", "", "Port for TLS connections (empty disables TLS; -port \"\" disables plain TCP)")
+	certFile := flag.String("cert", "cert.pem", "TLS certificate file")
+	keyFile := flag.String("key", "key.pem", "TLS private key file")
+	clientCA := flag.String("client-ca", "", "CA certificates for client certificates; a verified certificate's CN becomes the username")
+	requireClientCert := flag.Bool("require-client-cert", false, "Refuse TLS clients without a valid client certificate")
+	genCert := flag.Bool("gen-cert", false, "Write a self-signed certificate and key to -cert and -key, then exit")
+	certHosts := flag.String("cert-hosts", defaultCertHosts(), "Comma-separated host names and IPs for -gen-cert")
+	register := flag.String("register", "", "Create the account NAME with a password read from stdin, then exit (run it while the server is stopped)")
+	genClient := flag.String("gen-client", "", "Write NAME.pem and NAME-key.pem, a client certificate signed by -cert/-key, then exit")
 	flag.Parse()
 
-	startServer()
+	if *genCert {
+		if err := generateSelfSigned(*certFile, *keyFile, strings.Split(*certHosts, ",")); err != nil {
+			log.Fatalf("Error generating certificate: %v\n", err)
+		}
+		fmt.Printf("Wrote %s and %s\n", *certFile, *keyFile)
+		return
+	}
+	if *genClient != "" {
+		if err := generateClientCert(*genClient, *certFile, *keyFile); err != nil {
+			log.Fatalf("Error generating client certificate: %v\n", err)
+		}
+		fmt.Printf("Wrote %s.pem and %s-key.pem\n", *genClient, *genClient)
+		return
+	}
+	if *register != "" {
+		if err := loadAccounts(*accountsFile); err != nil {
+			log.Fatalf("Error loading accounts: %v\n", err)
+		}
+		if err := registerFromStdin(*register); err != nil {
+			log.Fatalf("Error registering %s: %v\n", *register, err)
+		}
+		fmt.Printf("Registered %s\n", *register)
+		return
+	}
+
+	if slowConsumerPolicy != "drop" && slowConsumerPolicy != "disconnect" {
+		log.Fatalf("Invalid -slow-consumer %q: use drop or disconnect\n", slowConsumerPolicy)
+	}
+	if sendQueueSize < 1 {
+		log.Fatalf("Invalid -send-queue %d: must be at least 1\n", sendQueueSize)
+	}
+
+	setReservedNames(*reserved)
+	if err := setupRoles(*ops, *opPass, *auditPath); err != nil {
+		log.Fatalf("Error setting up roles: %v\n", err)
+	}
+	if err := loadAccounts(*accountsFile); err != nil {
+		log.Fatalf("Error loading accounts: %v\n", err)
+	}
+	if err := loadState(*stateFile); err != nil {
+		log.Fatalf("Error loading state: %v\n", err)
+	}
+	go flushLogs()
+	if err := loadMailbox(*mailboxFile); err != nil {
+		log.Fatalf("Error loading mailbox: %v\n", err)
+	}
+
+	var tlsConfig *tls.Config
+	if *tlsPort != "" {
+		var err error
+		if tlsConfig, err = loadTLSConfig(*certFile, *keyFile, *clientCA, *requireClientCert); err != nil {
+			log.Fatalf("Error loading TLS configuration: %v\n", err)
+		}
+	} else if *port == "" {
+		log.Fatalf("Nothing to listen on: set -port or -tls-port\n")
+	}
+
+	startServer(*port, tlsConfig, *tlsPort)
 }